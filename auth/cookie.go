@@ -0,0 +1,33 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"time"
+)
+
+// Browser clients can be signed in with a remember token cookie
+// instead of a bearer JWT. The CSRF cookie is readable by scripts
+// of the client, which echo it in the CSRF header.
+const (
+	RememberCookie = "remember_token"
+	CSRFCookie     = "csrf_token"
+	CSRFHeader     = "X-CSRF-Token"
+	// How long browsers keep the cookies
+	RememberTTL = 30 * 24 * time.Hour
+)
+
+// CSRFToken derives the CSRF token of a remember token, so it
+// changes along with it and needs no storage
+func CSRFToken(rememberToken, signingKey string) string {
+	mac := hmac.New(sha256.New, []byte(signingKey))
+	mac.Write([]byte("csrf:" + rememberToken))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ValidCSRFToken reports whether token is the CSRF token of the
+// remember token
+func ValidCSRFToken(token, rememberToken, signingKey string) bool {
+	return hmac.Equal([]byte(token), []byte(CSRFToken(rememberToken, signingKey)))
+}
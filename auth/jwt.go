@@ -8,7 +8,12 @@ import (
 	jwt "github.com/dgrijalva/jwt-go"
 )
 
-func IssueJWT(user *models.User, signingKey string) (string, error) {
+// How long issued tokens are valid
+const TokenTTL = time.Hour
+
+// IssueJWT signs a token for the user, bound to the session
+// it was recorded as
+func IssueJWT(user *models.User, sessionID uint, signingKey string) (string, error) {
 	var signKey = []byte(signingKey)
 
 	token := jwt.New(jwt.SigningMethodHS256)
@@ -19,7 +24,8 @@ func IssueJWT(user *models.User, signingKey string) (string, error) {
 	// Set token claims
 	claims["role"] = "standard_user"
 	claims["logged_in_user_id"] = user.ID
-	claims["exp"] = time.Now().Add(time.Hour * time.Duration(1)).Unix()
+	claims["sid"] = sessionID
+	claims["exp"] = time.Now().Add(TokenTTL).Unix()
 	claims["iat"] = time.Now().Unix()
 
 	// Sign the token with the secret key
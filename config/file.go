@@ -0,0 +1,146 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Reads the settings of a config file, keyed like the
+// environment variables. Lists are joined with commas.
+func readConfigFile(path string) (map[string]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("config: %w", err)
+	}
+
+	var settings map[string]string
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".json":
+		settings, err = parseJSONSettings(data)
+	case ".yaml", ".yml":
+		settings, err = parseYAMLSettings(data)
+	default:
+		return nil, fmt.Errorf("config: %s: config files must be .json, .yaml or .yml", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config: %s: %w", path, err)
+	}
+	return settings, nil
+}
+
+// Reads an object of settings, with string, number, boolean
+// or list values
+func parseJSONSettings(data []byte) (map[string]string, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.UseNumber()
+	var values map[string]interface{}
+	err := dec.Decode(&values)
+	if err != nil {
+		return nil, err
+	}
+
+	settings := make(map[string]string, len(values))
+	for key, value := range values {
+		var list []interface{}
+		switch v := value.(type) {
+		case []interface{}:
+			list = v
+		default:
+			list = []interface{}{v}
+		}
+
+		items := make([]string, 0, len(list))
+		for _, item := range list {
+			switch v := item.(type) {
+			case string:
+				items = append(items, v)
+			case json.Number:
+				items = append(items, v.String())
+			case bool:
+				items = append(items, strconv.FormatBool(v))
+			default:
+				return nil, fmt.Errorf("%s must be a string, number, boolean or list of them", key)
+			}
+		}
+		settings[key] = strings.Join(items, ",")
+	}
+	return settings, nil
+}
+
+// Reads the YAML most config files need: "KEY: value" lines,
+// lists of "- value" lines under a "KEY:", and comments. Nested
+// mappings and multi-line values are not supported.
+func parseYAMLSettings(data []byte) (map[string]string, error) {
+	settings := make(map[string]string)
+	// The key "- value" lines belong to
+	var listKey string
+	for i, line := range strings.Split(string(data), "\n") {
+		lineNo := i + 1
+		trimmed := strings.TrimSpace(line)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "- ") || trimmed == "-" {
+			if listKey == "" {
+				return nil, fmt.Errorf("line %d: list item without a key", lineNo)
+			}
+			value, err := yamlScalar(strings.TrimPrefix(trimmed, "-"))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", lineNo, err)
+			}
+			if settings[listKey] != "" {
+				value = settings[listKey] + "," + value
+			}
+			settings[listKey] = value
+			continue
+		}
+
+		if line != strings.TrimLeft(line, " \t") {
+			return nil, fmt.Errorf("line %d: nested mappings are not supported", lineNo)
+		}
+		parts := strings.SplitN(trimmed, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("line %d: expected KEY: value", lineNo)
+		}
+		key := strings.TrimSpace(parts[0])
+		value, err := yamlScalar(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		settings[key] = value
+		listKey = ""
+		if value == "" {
+			listKey = key
+		}
+	}
+	return settings, nil
+}
+
+// Unquotes a value, or cuts the comment after it
+func yamlScalar(value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch {
+	case strings.HasPrefix(value, `"`):
+		end := strings.LastIndex(value, `"`)
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return strconv.Unquote(value[:end+1])
+	case strings.HasPrefix(value, "'"):
+		end := strings.LastIndex(value, "'")
+		if end == 0 {
+			return "", fmt.Errorf("unterminated string %s", value)
+		}
+		return strings.ReplaceAll(value[1:end], "''", "'"), nil
+	}
+	if i := strings.Index(value, " #"); i >= 0 {
+		value = strings.TrimSpace(value[:i])
+	}
+	return value, nil
+}
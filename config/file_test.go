@@ -0,0 +1,117 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAMLSettings(t *testing.T) {
+	data := `---
+# Comments and blank lines are skipped
+
+DB_NAME: my_dev_db
+DB_USER: "dev user" # quoted
+DB_PASSWORD: 'it''s # not a comment'
+DB_HOST: localhost # a comment
+OIDC_GOOGLE_SCOPES:
+  - email
+  - "profile"
+CORS_ALLOWED_ORIGINS:
+EMPTY_QUOTED: ""
+`
+	got, err := parseYAMLSettings([]byte(data))
+	if err != nil {
+		t.Fatalf("parseYAMLSettings() error = %v", err)
+	}
+	want := map[string]string{
+		"DB_NAME":              "my_dev_db",
+		"DB_USER":              "dev user",
+		"DB_PASSWORD":          "it's # not a comment",
+		"DB_HOST":              "localhost",
+		"OIDC_GOOGLE_SCOPES":   "email,profile",
+		"CORS_ALLOWED_ORIGINS": "",
+		"EMPTY_QUOTED":         "",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseYAMLSettings() = %v, want %v", got, want)
+	}
+}
+
+func TestParseYAMLSettingsErrors(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"list item without a key", "- email\n", "line 1: list item without a key"},
+		{"list item after a value", "DB_NAME: x\n- email\n", "line 2: list item without a key"},
+		{"nested mapping", "DATABASE:\n  NAME: x\n", "line 2: nested mappings are not supported"},
+		{"no colon", "DB_NAME\n", "line 1: expected KEY: value"},
+		{"no key", ": x\n", "line 1: expected KEY: value"},
+		{"unterminated string", "DB_NAME: \"x\n", "line 1: unterminated string"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := parseYAMLSettings([]byte(tt.data))
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Errorf("parseYAMLSettings() error = %v, want %q", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseJSONSettings(t *testing.T) {
+	data := `{"DB_NAME": "my_dev_db", "DB_PORT": 5433, "COOKIE_SECURE": false, "OIDC_GOOGLE_SCOPES": ["email", "profile"]}`
+	got, err := parseJSONSettings([]byte(data))
+	if err != nil {
+		t.Fatalf("parseJSONSettings() error = %v", err)
+	}
+	want := map[string]string{
+		"DB_NAME":            "my_dev_db",
+		"DB_PORT":            "5433",
+		"COOKIE_SECURE":      "false",
+		"OIDC_GOOGLE_SCOPES": "email,profile",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parseJSONSettings() = %v, want %v", got, want)
+	}
+
+	_, err = parseJSONSettings([]byte(`{"DATABASE": {"NAME": "x"}}`))
+	if err == nil {
+		t.Error("parseJSONSettings() of an object value error = nil, want an error")
+	}
+}
+
+func TestReadConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"config.json": `{"DB_NAME": "x"}`,
+		"config.yaml": "DB_NAME: x\n",
+		"config.yml":  "DB_NAME: x\n",
+		"config.toml": "DB_NAME = \"x\"\n",
+	}
+	for name, data := range files {
+		err := os.WriteFile(filepath.Join(dir, name), []byte(data), 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, name := range []string{"config.json", "config.yaml", "config.yml"} {
+		settings, err := readConfigFile(filepath.Join(dir, name))
+		if err != nil || settings["DB_NAME"] != "x" {
+			t.Errorf("readConfigFile(%s) = %v, %v, want DB_NAME x", name, settings, err)
+		}
+	}
+	_, err := readConfigFile(filepath.Join(dir, "config.toml"))
+	if err == nil || !strings.Contains(err.Error(), "must be .json, .yaml or .yml") {
+		t.Errorf("readConfigFile(config.toml) error = %v, want an unsupported format", err)
+	}
+	_, err = readConfigFile(filepath.Join(dir, "missing.json"))
+	if err == nil {
+		t.Error("readConfigFile() of a missing file error = nil, want an error")
+	}
+}
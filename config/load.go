@@ -0,0 +1,227 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// Load reads the configuration once, at startup. Settings are
+// named by the env tags of Config, and are taken from, first
+// to last:
+//
+//   - flags, named like the setting in lower case with dashes,
+//     e.g. -db-port 5432. Settings tagged secret have no flag,
+//     as anyone listing processes can read command lines.
+//   - environment variables, when not empty
+//   - the JSON or YAML file given by -config or CONFIG_FILE
+//   - defaults
+//
+// Settings that cannot be parsed or are missing or invalid are
+// all reported together, as ValidationErrors.
+func Load(args []string) (Config, error) {
+	fs := flag.NewFlagSet("go_rest_pg_starter", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "JSON or YAML config file")
+	flagValues := make(map[string]*string)
+	for _, key := range flagKeys(reflect.TypeOf(Config{})) {
+		flagValues[key] = fs.String(flagName(key), "", "sets "+key)
+	}
+	err := fs.Parse(args)
+	if err != nil {
+		return Config{}, err
+	}
+	flagsSet := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		flagsSet[f.Name] = true
+	})
+
+	var file map[string]string
+	if *configFile != "" {
+		file, err = readConfigFile(*configFile)
+		if err != nil {
+			return Config{}, err
+		}
+	}
+
+	looked := make(map[string]bool)
+	lookup := func(key string) (string, bool) {
+		looked[key] = true
+		if flagsSet[flagName(key)] {
+			return *flagValues[key], true
+		}
+		if value := os.Getenv(key); value != "" {
+			return value, true
+		}
+		value, ok := file[key]
+		return value, ok
+	}
+
+	cfg := defaultConfig()
+	var errs ValidationErrors
+	errs = append(errs, loadStruct(reflect.ValueOf(&cfg).Elem(), "", lookup)...)
+	err = cfg.Database.applyURL()
+	if err != nil {
+		errs = append(errs, "DATABASE_URL "+err.Error())
+	}
+	providers, providerErrs := loadOIDCProviders(lookup)
+	cfg.OIDCProviders = providers
+	errs = append(errs, providerErrs...)
+
+	// Most likely typos
+	var unknown []string
+	for key := range file {
+		if !looked[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	sort.Strings(unknown)
+	for _, key := range unknown {
+		errs = append(errs, fmt.Sprintf("%s is not a setting, in %s", key, *configFile))
+	}
+
+	errs = append(errs, cfg.validate()...)
+	if len(errs) > 0 {
+		return cfg, errs
+	}
+	return cfg, nil
+}
+
+// Sets the fields of v with an env tag from the settings
+// lookup finds. <NAME> in tags is replaced by name.
+func loadStruct(v reflect.Value, name string, lookup func(string) (string, bool)) ValidationErrors {
+	var errs ValidationErrors
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("env")
+		if key == "" {
+			if field.Type.Kind() == reflect.Struct {
+				errs = append(errs, loadStruct(v.Field(i), name, lookup)...)
+			}
+			continue
+		}
+		// Lists of settings, see loadOIDCProviders
+		if field.Type.Kind() == reflect.Slice && field.Type.Elem().Kind() == reflect.Struct {
+			continue
+		}
+
+		key = strings.Replace(key, "<NAME>", name, 1)
+		raw, ok := lookup(key)
+		if !ok {
+			continue
+		}
+		err := setValue(v.Field(i), raw, field.Tag.Get("sep"))
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", key, err))
+		}
+	}
+	return errs
+}
+
+// Reads the providers listed in OIDC_PROVIDERS, each
+// configured by OIDC_<NAME>_* settings
+func loadOIDCProviders(lookup func(string) (string, bool)) ([]OIDCProviderConfig, ValidationErrors) {
+	names, _ := lookup("OIDC_PROVIDERS")
+	var providers []OIDCProviderConfig
+	var errs ValidationErrors
+	for _, name := range parseList(names) {
+		provider := OIDCProviderConfig{
+			Name:   strings.ToLower(name),
+			Scopes: defaultOIDCScopes,
+		}
+		errs = append(errs, loadStruct(reflect.ValueOf(&provider).Elem(), strings.ToUpper(name), lookup)...)
+		providers = append(providers, provider)
+	}
+	return providers, errs
+}
+
+// Parses raw into v. sep separates the keys and values of maps
+// ("=" by default), and " " splits lists on spaces as well as
+// commas.
+func setValue(v reflect.Value, raw, sep string) error {
+	switch v.Kind() {
+	case reflect.String:
+		v.SetString(raw)
+	case reflect.Int:
+		n, err := strconv.Atoi(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not a whole number", raw)
+		}
+		v.SetInt(int64(n))
+	case reflect.Float64:
+		f, err := strconv.ParseFloat(raw, 64)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", raw)
+		}
+		v.SetFloat(f)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return fmt.Errorf("%q is not true or false", raw)
+		}
+		v.SetBool(b)
+	case reflect.Slice:
+		list := parseList(raw)
+		if sep == " " {
+			list = strings.FieldsFunc(raw, func(r rune) bool {
+				return r == ',' || unicode.IsSpace(r)
+			})
+		}
+		v.Set(reflect.ValueOf(list))
+	case reflect.Map:
+		if sep == "" {
+			sep = "="
+		}
+		entries := make(map[string]string)
+		for _, entry := range parseList(raw) {
+			parts := strings.SplitN(entry, sep, 2)
+			if len(parts) != 2 {
+				return fmt.Errorf("entries must be key%svalue, got %q", sep, entry)
+			}
+			entries[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+		v.Set(reflect.ValueOf(entries))
+	default:
+		return fmt.Errorf("settings of type %s are not supported", v.Type())
+	}
+	return nil
+}
+
+// The settings of the fields of t, and of its nested structs,
+// but not the ones of lists of settings nor secrets, see Load
+func flagKeys(t reflect.Type) []string {
+	var keys []string
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		key := field.Tag.Get("env")
+		switch {
+		case key == "" && field.Type.Kind() == reflect.Struct:
+			keys = append(keys, flagKeys(field.Type)...)
+		case key != "" && field.Tag.Get("secret") != "true":
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// DB_PORT is set by -db-port
+func flagName(key string) string {
+	return strings.ReplaceAll(strings.ToLower(key), "_", "-")
+}
+
+// Parses "a,b,c", leaving out empty entries
+func parseList(value string) []string {
+	var list []string
+	for _, entry := range strings.Split(value, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry != "" {
+			list = append(list, entry)
+		}
+	}
+	return list
+}
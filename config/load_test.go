@@ -0,0 +1,155 @@
+package config
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// Sets the environment variables for the test, and ignores the
+// settings of the environment running it
+func setEnv(t *testing.T, env map[string]string) {
+	t.Helper()
+	t.Setenv("CONFIG_FILE", "")
+	for _, key := range flagKeys(reflect.TypeOf(Config{})) {
+		t.Setenv(key, "")
+	}
+	for _, key := range []string{"PEPPER", "HMAC_KEY", "JWT_SIGN_KEY", "DB_PASSWORD", "DATABASE_URL", "OIDC_PROVIDERS"} {
+		t.Setenv(key, "")
+	}
+	for key, value := range env {
+		t.Setenv(key, value)
+	}
+}
+
+func writeConfigFile(t *testing.T, name, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	err := os.WriteFile(path, []byte(data), 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+var requiredEnv = map[string]string{
+	"JWT_SIGN_KEY": "jwt-key",
+	"HMAC_KEY":     "hmac-key",
+	"DB_NAME":      "my_dev_db",
+}
+
+func TestLoadPrecedence(t *testing.T) {
+	env := map[string]string{
+		"DB_HOST": "env-host",
+		"DB_PORT": "5434",
+		// Empty variables are ignored
+		"DB_USER": "",
+	}
+	for key, value := range requiredEnv {
+		env[key] = value
+	}
+	setEnv(t, env)
+	path := writeConfigFile(t, "config.yaml", `
+DB_HOST: file-host
+DB_PORT: 5433
+DB_USER: file-user
+STORAGE_LOCAL_DIR: file-uploads
+`)
+
+	cfg, err := Load([]string{"-config", path, "-db-host", "flag-host"})
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	tests := []struct {
+		name string
+		got  interface{}
+		want interface{}
+	}{
+		{"flag over env and file", cfg.Database.Host, "flag-host"},
+		{"env over file", cfg.Database.Port, 5434},
+		{"file over empty env", cfg.Database.User, "file-user"},
+		{"file over default", cfg.Storage.LocalDir, "file-uploads"},
+		{"default", cfg.Database.MaxOpenConns, 10},
+		{"default bool", cfg.CookieSecure, true},
+	}
+	for _, tt := range tests {
+		if tt.got != tt.want {
+			t.Errorf("%s: got %v, want %v", tt.name, tt.got, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigFileFromEnv(t *testing.T) {
+	setEnv(t, requiredEnv)
+	t.Setenv("CONFIG_FILE", writeConfigFile(t, "config.json", `{"DB_PORT": 5433, "COOKIE_SECURE": false}`))
+
+	cfg, err := Load(nil)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if cfg.Database.Port != 5433 || cfg.CookieSecure {
+		t.Errorf("Load() DB_PORT = %d, COOKIE_SECURE = %v, want the file's 5433 and false",
+			cfg.Database.Port, cfg.CookieSecure)
+	}
+}
+
+func TestLoadSecretsHaveNoFlags(t *testing.T) {
+	setEnv(t, requiredEnv)
+
+	secrets := []string{"PEPPER", "HMAC_KEY", "JWT_SIGN_KEY", "DB_PASSWORD", "DATABASE_URL", "METRICS_TOKEN", "OLD_PEPPERS", "MAILGUN_API_KEY"}
+	keys := flagKeys(reflect.TypeOf(Config{}))
+	for _, secret := range secrets {
+		for _, key := range keys {
+			if key == secret {
+				t.Errorf("%s has a flag", secret)
+			}
+		}
+
+		_, err := Load([]string{"-" + flagName(secret), "value"})
+		if err == nil || !strings.Contains(err.Error(), "flag provided but not defined") {
+			t.Errorf("Load(-%s) error = %v, want an undefined flag", flagName(secret), err)
+		}
+	}
+}
+
+func TestLoadValidationErrors(t *testing.T) {
+	setEnv(t, map[string]string{
+		"DB_PORT":        "not a port",
+		"STORAGE_DRIVER": "ftp",
+		"COOKIE_SECURE":  "maybe",
+		"OIDC_PROVIDERS": "google",
+	})
+	path := writeConfigFile(t, "config.yaml", "DB_NAMEE: typo\n")
+
+	_, err := Load([]string{"-config", path})
+	var errs ValidationErrors
+	if !errors.As(err, &errs) {
+		t.Fatalf("Load() error = %v, want ValidationErrors", err)
+	}
+	// Everything is reported at once
+	want := []string{
+		`DB_PORT: "not a port" is not a whole number`,
+		`COOKIE_SECURE: "maybe" is not true or false`,
+		"DB_NAMEE is not a setting, in " + path,
+		"JWT_SIGN_KEY is required",
+		"HMAC_KEY is required",
+		"DB_NAME is required",
+		`STORAGE_DRIVER must be one of ["local" "s3"], got "ftp"`,
+		"OIDC_GOOGLE_ISSUER is required",
+		"OIDC_GOOGLE_CLIENT_ID is required",
+	}
+	for _, w := range want {
+		found := false
+		for _, e := range errs {
+			if e == w {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("ValidationErrors = %q, missing %q", errs, w)
+		}
+	}
+}
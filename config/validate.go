@@ -0,0 +1,112 @@
+package config
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Signing and HMAC keys shorter than this are refused in production
+const minKeyLength = 32
+
+// ValidationErrors lists everything wrong with the settings,
+// so that it can all be fixed at once
+type ValidationErrors []string
+
+func (ve ValidationErrors) Error() string {
+	return "config: invalid settings:\n  - " + strings.Join(ve, "\n  - ")
+}
+
+func (c Config) validate() ValidationErrors {
+	var errs ValidationErrors
+	required := func(key, value string) {
+		if value == "" {
+			errs = append(errs, key+" is required")
+		}
+	}
+	oneOf := func(key, value string, allowed ...string) {
+		for _, a := range allowed {
+			if value == a {
+				return
+			}
+		}
+		errs = append(errs, fmt.Sprintf("%s must be one of %q, got %q", key, allowed, value))
+	}
+	atLeast := func(key string, value, min int) {
+		if value < min {
+			errs = append(errs, fmt.Sprintf("%s must be at least %d, got %d", key, min, value))
+		}
+	}
+
+	required("JWT_SIGN_KEY", c.SigningKey)
+	required("HMAC_KEY", c.HMACKey)
+	required("DB_NAME", c.Database.Name)
+	if c.IsProd() {
+		required("PEPPER", c.Pepper)
+		if c.SigningKey != "" && len(c.SigningKey) < minKeyLength {
+			errs = append(errs, fmt.Sprintf("JWT_SIGN_KEY must be at least %d characters in production", minKeyLength))
+		}
+		if c.HMACKey != "" && len(c.HMACKey) < minKeyLength {
+			errs = append(errs, fmt.Sprintf("HMAC_KEY must be at least %d characters in production", minKeyLength))
+		}
+	}
+	if c.Database.Port < 1 || c.Database.Port > 65535 {
+		errs = append(errs, fmt.Sprintf("DB_PORT must be a port number, got %d", c.Database.Port))
+	}
+	oneOf("DB_SSLMODE", c.Database.SSLMode, "", "disable", "require", "verify-ca", "verify-full")
+	if (c.Database.SSLCert == "") != (c.Database.SSLKey == "") {
+		errs = append(errs, "DB_SSLCERT and DB_SSLKEY must be set together")
+	}
+	atLeast("DB_CONNECT_TIMEOUT_SECONDS", c.Database.ConnectTimeoutSeconds, 0)
+	atLeast("DB_MAX_OPEN_CONNS", c.Database.MaxOpenConns, 0)
+	atLeast("DB_MAX_IDLE_CONNS", c.Database.MaxIdleConns, 0)
+	atLeast("DB_CONN_MAX_LIFETIME_SECONDS", c.Database.ConnMaxLifetimeSeconds, 0)
+
+	oneOf("STORAGE_DRIVER", c.Storage.Driver, "local", "s3")
+	if c.Storage.Driver == "s3" {
+		required("S3_BUCKET", c.Storage.S3Bucket)
+		required("S3_ACCESS_KEY_ID", c.Storage.S3AccessKeyID)
+		required("S3_SECRET_ACCESS_KEY", c.Storage.S3SecretAccessKey)
+	}
+	atLeast("MAX_UPLOAD_MB", c.Storage.MaxUploadMB, 1)
+
+	oneOf("PASSWORD_HASH_ALGORITHM", c.Password.HashAlgorithm, "argon2id", "bcrypt")
+	atLeast("PASSWORD_MIN_LENGTH", c.Password.MinLength, 1)
+	if c.Password.MaxLength < c.Password.MinLength {
+		errs = append(errs, "PASSWORD_MAX_LENGTH must not be less than PASSWORD_MIN_LENGTH")
+	}
+
+	required("PORT", c.Server.Port)
+	atLeast("SERVER_READ_HEADER_TIMEOUT_SECONDS", c.Server.ReadHeaderTimeoutSeconds, 0)
+	atLeast("SERVER_READ_TIMEOUT_SECONDS", c.Server.ReadTimeoutSeconds, 0)
+	atLeast("SERVER_WRITE_TIMEOUT_SECONDS", c.Server.WriteTimeoutSeconds, 0)
+	atLeast("SERVER_IDLE_TIMEOUT_SECONDS", c.Server.IdleTimeoutSeconds, 0)
+	atLeast("SERVER_SHUTDOWN_DELAY_SECONDS", c.Server.ShutdownDelaySeconds, 0)
+	atLeast("SERVER_SHUTDOWN_TIMEOUT_SECONDS", c.Server.ShutdownTimeoutSeconds, 0)
+	atLeast("SERVER_JOBS_SHUTDOWN_TIMEOUT_SECONDS", c.Server.JobsShutdownTimeoutSeconds, 0)
+	atLeast("SERVER_MAX_POST_BODY_KB", c.Server.MaxPostBodyKB, 1)
+	atLeast("SERVER_MAX_BODY_KB", c.Server.MaxBodyKB, 1)
+
+	atLeast("TRASH_RETENTION_DAYS", c.TrashRetentionDays, 0)
+	atLeast("EXPORT_VALID_DAYS", c.ExportValidDays, 1)
+
+	oneOf("TRACING_EXPORTER", c.Tracing.Exporter, "", "otlp", "stdout")
+	if c.Tracing.SampleRatio < 0 || c.Tracing.SampleRatio > 1 {
+		errs = append(errs, fmt.Sprintf("TRACING_SAMPLE_RATIO must be between 0 and 1, got %g", c.Tracing.SampleRatio))
+	}
+	required("PUBLIC_BASE_URL", c.PublicBaseURL)
+	oneOf("LOG_LEVEL", strings.ToLower(c.LogLevel), "debug", "info", "warn", "error")
+
+	for _, origin := range c.CORS.AllowedOrigins {
+		// Would let any site act as the signed in user
+		if origin == "*" && c.CORS.AllowCredentials {
+			errs = append(errs, "CORS_ALLOW_CREDENTIALS cannot be used with CORS_ALLOWED_ORIGINS=*")
+		}
+	}
+
+	for _, provider := range c.OIDCProviders {
+		prefix := "OIDC_" + strings.ToUpper(provider.Name) + "_"
+		required(prefix+"ISSUER", provider.Issuer)
+		required(prefix+"CLIENT_ID", provider.ClientID)
+	}
+	return errs
+}
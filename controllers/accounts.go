@@ -0,0 +1,322 @@
+package controllers
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"go_rest_pg_starter/email"
+	"go_rest_pg_starter/jobs"
+	"go_rest_pg_starter/logging"
+	"go_rest_pg_starter/middlewares"
+	"go_rest_pg_starter/models"
+	"go_rest_pg_starter/storage"
+	"go_rest_pg_starter/utils"
+
+	"github.com/gorilla/mux"
+)
+
+type Accounts struct {
+	as      models.AccountService
+	us      models.UserService
+	emailer *email.Client
+	blobs   storage.BlobStore
+	signer  *storage.URLSigner
+	queue   *jobs.Queue
+	// Data exports can be downloaded for this many days
+	exportValidDays int
+}
+
+type DeleteAccountUser struct {
+	Password string `schema:"password"`
+}
+
+// ExportedProfile is the profile as written in data exports
+type ExportedProfile struct {
+	ID          uint      `json:"id"`
+	Username    string    `json:"username"`
+	Email       string    `json:"email"`
+	DisplayName string    `json:"display_name"`
+	Bio         string    `json:"bio"`
+	AvatarURL   string    `json:"avatar_url"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+	UpdatedAt   time.Time `json:"updated_at"`
+}
+
+type ExportedAttachment struct {
+	ID          uint      `json:"id"`
+	PostID      uint      `json:"post_id"`
+	FileName    string    `json:"file_name"`
+	ContentType string    `json:"content_type"`
+	Size        int64     `json:"size"`
+	CreatedAt   time.Time `json:"created_at"`
+	// Where the file is in the archive
+	Path string `json:"path"`
+}
+
+type ExportedReaction struct {
+	PostID    uint      `json:"post_id"`
+	Kind      string    `json:"kind"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func NewAccounts(as models.AccountService, us models.UserService, emailer *email.Client, blobs storage.BlobStore, signer *storage.URLSigner, queue *jobs.Queue, exportValidDays int) *Accounts {
+	return &Accounts{
+		as:              as,
+		us:              us,
+		emailer:         emailer,
+		blobs:           blobs,
+		signer:          signer,
+		queue:           queue,
+		exportValidDays: exportValidDays,
+	}
+}
+
+// DELETE /api/me
+// Delete the account of the logged in user, who must
+// confirm it with their password
+func (a *Accounts) Delete(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	var deleteAccountUser DeleteAccountUser
+	err := decodeJSON(r, &deleteAccountUser)
+	if err != nil {
+		sendDecodeError(w, err, http.StatusBadRequest, "Cannot get the password.")
+		return
+	}
+
+	_, err = a.us.WithContext(r.Context()).Authenticate(user.UserEmail, deleteAccountUser.Password)
+	if err != nil {
+		sendErrorResponse(w, http.StatusForbidden, "Password is incorrect.")
+		return
+	}
+
+	blobKeys, err := a.as.WithContext(r.Context()).Delete(user.ID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Could not delete the account.")
+		return
+	}
+	for _, key := range blobKeys {
+		a.blobs.Delete(r.Context(), key)
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// POST /api/me/export
+// Build an archive of the user's data in the background and
+// email them a link to download it once it is ready
+func (a *Accounts) Export(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	userID := user.ID
+	logger := logging.FromContext(r.Context())
+	err := a.queue.Enqueue(func(ctx context.Context) {
+		err := a.export(ctx, userID)
+		if err != nil {
+			logger.Error("data export failed", "user_id", userID, "error", err)
+		}
+	})
+	if err != nil {
+		sendErrorResponse(w, http.StatusServiceUnavailable, "Cannot export your data right now, please try again later.")
+		return
+	}
+
+	setSuccessStatus(w, http.StatusAccepted)
+	json.NewEncoder(w).Encode(ErrorMessage{Message: "Your data export has started. You will get an email once it is ready."})
+}
+
+// GET /api/exports/:id/download?expires=&signature=
+func (a *Accounts) Download(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || !a.signer.Verify(r.URL.Path, expires, query.Get("signature"), time.Now()) {
+		sendErrorResponse(w, http.StatusForbidden, "This link is invalid or has expired.")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Export not found.")
+		return
+	}
+	export, err := a.as.WithContext(r.Context()).GetExport(uint(id))
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Export not found.")
+		return
+	}
+
+	blob, err := a.blobs.Get(r.Context(), export.BlobKey)
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Export not found.")
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="export-%s.zip"`,
+		export.CreatedAt.Format("2006-01-02")))
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, blob)
+}
+
+// ------ Helper ------
+
+func (a *Accounts) export(ctx context.Context, userID uint) error {
+	data, err := a.as.WithContext(ctx).Collect(userID)
+	if err != nil {
+		return err
+	}
+
+	archive, err := ioutil.TempFile("", "export-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(archive.Name())
+	defer archive.Close()
+
+	err = a.writeArchive(ctx, archive, data)
+	if err != nil {
+		return err
+	}
+	size, err := archive.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return err
+	}
+	_, err = archive.Seek(0, io.SeekStart)
+	if err != nil {
+		return err
+	}
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("exports/%d/%s.zip", userID, token)
+	err = a.blobs.Put(ctx, key, archive, size, "application/zip")
+	if err != nil {
+		return err
+	}
+
+	export := models.DataExport{
+		UserID:  userID,
+		BlobKey: key,
+	}
+	err = a.as.WithContext(ctx).CreateExport(&export)
+	if err != nil {
+		a.blobs.Delete(ctx, key)
+		return err
+	}
+
+	path := fmt.Sprintf("/api/exports/%d/download", export.ID)
+	expires := time.Now().AddDate(0, 0, a.exportValidDays)
+	v := url.Values{}
+	v.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	v.Set("signature", a.signer.Sign(path, expires))
+	return a.emailer.DataExportReady(ctx, data.User.Email, path+"?"+v.Encode(), a.exportValidDays)
+}
+
+func (a *Accounts) writeArchive(ctx context.Context, w io.Writer, data *models.AccountData) error {
+	zw := zip.NewWriter(w)
+
+	attachments := make([]ExportedAttachment, 0, len(data.Attachments))
+	for _, attachment := range data.Attachments {
+		attachments = append(attachments, ExportedAttachment{
+			ID:          attachment.ID,
+			PostID:      attachment.PostID,
+			FileName:    attachment.FileName,
+			ContentType: attachment.ContentType,
+			Size:        attachment.Size,
+			CreatedAt:   attachment.CreatedAt,
+			Path:        exportedAttachmentPath(&attachment),
+		})
+	}
+
+	reactions := make([]ExportedReaction, 0, len(data.Reactions))
+	for _, reaction := range data.Reactions {
+		reactions = append(reactions, ExportedReaction{
+			PostID:    reaction.PostID,
+			Kind:      reaction.Kind,
+			CreatedAt: reaction.CreatedAt,
+		})
+	}
+
+	files := []struct {
+		name    string
+		content interface{}
+	}{
+		{"profile.json", ExportedProfile{
+			ID:          data.User.ID,
+			Username:    data.User.Username,
+			Email:       data.User.Email,
+			DisplayName: data.User.DisplayName,
+			Bio:         data.User.Bio,
+			AvatarURL:   data.User.AvatarURL,
+			Role:        data.User.Role,
+			CreatedAt:   data.User.CreatedAt,
+			UpdatedAt:   data.User.UpdatedAt,
+		}},
+		{"posts.json", data.Posts},
+		{"attachments.json", attachments},
+		{"reactions.json", reactions},
+		{"following.json", newPublicUsers(data.Following)},
+		{"followers.json", newPublicUsers(data.Followers)},
+	}
+	for _, file := range files {
+		f, err := zw.Create(file.name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(file.content)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, attachment := range data.Attachments {
+		err := a.copyBlob(ctx, zw, exportedAttachmentPath(&attachment), attachment.BlobKey)
+		if err != nil && err != storage.ErrNotFound {
+			return err
+		}
+	}
+
+	return zw.Close()
+}
+
+func exportedAttachmentPath(attachment *models.Attachment) string {
+	return fmt.Sprintf("attachments/%d-%s", attachment.ID, attachment.FileName)
+}
+
+func (a *Accounts) copyBlob(ctx context.Context, zw *zip.Writer, name, key string) error {
+	blob, err := a.blobs.Get(ctx, key)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	f, err := zw.Create(name)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(f, blob)
+	return err
+}
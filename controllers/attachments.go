@@ -0,0 +1,358 @@
+package controllers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"go_rest_pg_starter/middlewares"
+	"go_rest_pg_starter/models"
+	"go_rest_pg_starter/storage"
+	"go_rest_pg_starter/utils"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	// How long download links stay valid
+	downloadURLTTL   = 15 * time.Minute
+	thumbnailSize    = 256
+	thumbnailVariant = "thumbnail"
+)
+
+// Content types sniffed from uploads that are accepted
+var allowedUploadTypes = map[string]bool{
+	"image/jpeg":                true,
+	"image/png":                 true,
+	"image/gif":                 true,
+	"image/webp":                true,
+	"application/pdf":           true,
+	"text/plain; charset=utf-8": true,
+}
+
+// Types a thumbnail can be made from
+var thumbnailTypes = map[string]bool{
+	"image/jpeg": true,
+	"image/png":  true,
+	"image/gif":  true,
+}
+
+type Attachments struct {
+	as             models.AttachmentService
+	ps             models.PostService
+	blobs          storage.BlobStore
+	signer         *storage.URLSigner
+	maxUploadBytes int64
+}
+
+// AttachmentResponse is an attachment with signed, expiring
+// links to download it and its thumbnail
+type AttachmentResponse struct {
+	ID           uint      `json:"id"`
+	PostID       uint      `json:"post_id"`
+	FileName     string    `json:"file_name"`
+	ContentType  string    `json:"content_type"`
+	Size         int64     `json:"size"`
+	CreatedAt    time.Time `json:"created_at"`
+	URL          string    `json:"url"`
+	ThumbnailURL string    `json:"thumbnail_url,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+func NewAttachments(as models.AttachmentService, ps models.PostService, blobs storage.BlobStore, signer *storage.URLSigner, maxUploadBytes int64) *Attachments {
+	return &Attachments{
+		as:             as,
+		ps:             ps,
+		blobs:          blobs,
+		signer:         signer,
+		maxUploadBytes: maxUploadBytes,
+	}
+}
+
+// POST /api/posts/:id/attachments
+// Upload a file as multipart/form-data in the "file" field
+func (a *Attachments) Create(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	post, err := a.getPost(w, r)
+	if err != nil {
+		return
+	}
+	if post.UserID != user.ID {
+		sendErrorResponse(w, http.StatusForbidden, "You do not have permission.")
+		return
+	}
+
+	// Leave some room for the multipart headers
+	r.Body = http.MaxBytesReader(w, r.Body, a.maxUploadBytes+64*1024)
+	file, header, err := r.FormFile("file")
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Could not read the uploaded file.")
+		return
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, a.maxUploadBytes+1))
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Could not read the uploaded file.")
+		return
+	}
+	if int64(len(data)) > a.maxUploadBytes {
+		sendErrorResponse(w, http.StatusRequestEntityTooLarge,
+			fmt.Sprintf("Files must be at most %d bytes.", a.maxUploadBytes))
+		return
+	}
+
+	// Trust the content, not what the client claims it is
+	contentType := http.DetectContentType(data)
+	if !allowedUploadTypes[contentType] {
+		sendErrorResponse(w, http.StatusUnsupportedMediaType, "This type of file is not allowed.")
+		return
+	}
+
+	token, err := utils.GenerateToken()
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		return
+	}
+	key := fmt.Sprintf("attachments/%d/%s", post.ID, token)
+
+	err = a.blobs.Put(r.Context(), key, bytes.NewReader(data), int64(len(data)), contentType)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Could not store the file.")
+		return
+	}
+
+	attachment := models.Attachment{
+		PostID:      post.ID,
+		UserID:      user.ID,
+		FileName:    header.Filename,
+		ContentType: contentType,
+		Size:        int64(len(data)),
+		BlobKey:     key,
+	}
+
+	// Files that fail to decode simply get no thumbnail
+	if thumbnailTypes[contentType] {
+		thumb, thumbType, err := utils.Thumbnail(data, thumbnailSize)
+		if err == nil {
+			thumbKey := key + "-" + thumbnailVariant
+			err = a.blobs.Put(r.Context(), thumbKey, bytes.NewReader(thumb), int64(len(thumb)), thumbType)
+			if err == nil {
+				attachment.ThumbnailKey = thumbKey
+				attachment.ThumbnailContentType = thumbType
+			}
+		}
+	}
+
+	err = a.as.WithContext(r.Context()).Create(&attachment)
+	if err != nil {
+		a.deleteBlobs(r, &attachment)
+		sendErrorResponse(w, http.StatusInternalServerError, "Could not save the attachment.")
+		return
+	}
+
+	setSuccessStatus(w, http.StatusCreated)
+	json.NewEncoder(w).Encode(a.newResponse(&attachment))
+}
+
+// GET /api/posts/:id/attachments
+func (a *Attachments) List(w http.ResponseWriter, r *http.Request) {
+	post, err := a.getPost(w, r)
+	if err != nil {
+		return
+	}
+
+	var userID uint
+	if user := middlewares.LookUpUserFromContext(r.Context()); user != nil {
+		userID = user.ID
+	}
+	if !post.IsVisibleTo(userID) {
+		sendErrorResponse(w, http.StatusNotFound, "Post not found.")
+		return
+	}
+
+	attachments, err := a.as.WithContext(r.Context()).GetAllByPostId(post.ID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Could not get the attachments.")
+		return
+	}
+
+	response := make([]AttachmentResponse, 0, len(attachments))
+	for i := range attachments {
+		response = append(response, a.newResponse(&attachments[i]))
+	}
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+// DELETE /api/posts/:id/attachments/:attachment_id
+func (a *Attachments) Delete(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	attachment, err := a.getAttachment(w, r, "attachment_id")
+	if err != nil {
+		return
+	}
+	if attachment.UserID != user.ID || strconv.Itoa(int(attachment.PostID)) != mux.Vars(r)["id"] {
+		sendErrorResponse(w, http.StatusForbidden, "You do not have permission.")
+		return
+	}
+
+	err = a.as.WithContext(r.Context()).Delete(attachment.ID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Could not delete the attachment.")
+		return
+	}
+	a.deleteBlobs(r, attachment)
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GET /api/attachments/:id/download?variant=&expires=&signature=
+// Anyone holding a link signed by newResponse can download
+// the file until the link expires.
+func (a *Attachments) Download(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	variant := query.Get("variant")
+	expires, err := strconv.ParseInt(query.Get("expires"), 10, 64)
+	if err != nil || !a.signer.Verify(downloadResource(r.URL.Path, variant), expires, query.Get("signature"), time.Now()) {
+		sendErrorResponse(w, http.StatusForbidden, "This link is invalid or has expired.")
+		return
+	}
+
+	attachment, err := a.getAttachment(w, r, "id")
+	if err != nil {
+		return
+	}
+
+	key, contentType := attachment.BlobKey, attachment.ContentType
+	if variant == thumbnailVariant {
+		if attachment.ThumbnailKey == "" {
+			sendErrorResponse(w, http.StatusNotFound, "Thumbnail not found.")
+			return
+		}
+		key, contentType = attachment.ThumbnailKey, attachment.ThumbnailContentType
+	}
+
+	blob, err := a.blobs.Get(r.Context(), key)
+	if err != nil {
+		switch err {
+		case storage.ErrNotFound:
+			sendErrorResponse(w, http.StatusNotFound, "File not found.")
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Could not read the file.")
+		}
+		return
+	}
+	defer blob.Close()
+
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q; filename*=UTF-8''%s",
+		attachment.FileName, url.PathEscape(attachment.FileName)))
+	if variant != thumbnailVariant {
+		w.Header().Set("Content-Length", strconv.FormatInt(attachment.Size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, blob)
+}
+
+// ------ Helper ------
+
+func (a *Attachments) newResponse(attachment *models.Attachment) AttachmentResponse {
+	expires := time.Now().Add(downloadURLTTL)
+	response := AttachmentResponse{
+		ID:          attachment.ID,
+		PostID:      attachment.PostID,
+		FileName:    attachment.FileName,
+		ContentType: attachment.ContentType,
+		Size:        attachment.Size,
+		CreatedAt:   attachment.CreatedAt,
+		URL:         a.signedDownloadURL(attachment.ID, "", expires),
+		ExpiresAt:   expires,
+	}
+	if attachment.ThumbnailKey != "" {
+		response.ThumbnailURL = a.signedDownloadURL(attachment.ID, thumbnailVariant, expires)
+	}
+	return response
+}
+
+func (a *Attachments) signedDownloadURL(id uint, variant string, expires time.Time) string {
+	path := fmt.Sprintf("/api/attachments/%d/download", id)
+	v := url.Values{}
+	if variant != "" {
+		v.Set("variant", variant)
+	}
+	v.Set("expires", strconv.FormatInt(expires.Unix(), 10))
+	v.Set("signature", a.signer.Sign(downloadResource(path, variant), expires))
+	return path + "?" + v.Encode()
+}
+
+func downloadResource(path, variant string) string {
+	return path + "#" + variant
+}
+
+// Blobs left behind by a failed delete are only wasted
+// space, so errors are ignored
+func (a *Attachments) deleteBlobs(r *http.Request, attachment *models.Attachment) {
+	a.blobs.Delete(r.Context(), attachment.BlobKey)
+	if attachment.ThumbnailKey != "" {
+		a.blobs.Delete(r.Context(), attachment.ThumbnailKey)
+	}
+}
+
+func (a *Attachments) getPost(w http.ResponseWriter, r *http.Request) (*models.Post, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Invalid Post ID.")
+		return nil, err
+	}
+
+	post, err := a.ps.WithContext(r.Context()).GetOneById(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			sendErrorResponse(w, http.StatusNotFound, "Post not found.")
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		}
+		return nil, err
+	}
+	return post, nil
+}
+
+func (a *Attachments) getAttachment(w http.ResponseWriter, r *http.Request, param string) (*models.Attachment, error) {
+	id, err := strconv.Atoi(mux.Vars(r)[param])
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Invalid Attachment ID.")
+		return nil, err
+	}
+
+	attachment, err := a.as.WithContext(r.Context()).GetOneById(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			sendErrorResponse(w, http.StatusNotFound, "Attachment not found.")
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		}
+		return nil, err
+	}
+	return attachment, nil
+}
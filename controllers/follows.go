@@ -0,0 +1,114 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"go_rest_pg_starter/middlewares"
+	"go_rest_pg_starter/models"
+
+	"github.com/gorilla/mux"
+)
+
+type Follows struct {
+	fs models.FollowService
+	us models.UserService
+}
+
+func NewFollows(fs models.FollowService, us models.UserService) *Follows {
+	return &Follows{
+		fs: fs,
+		us: us,
+	}
+}
+
+// POST /api/users/:id/follow
+func (f *Follows) Follow(w http.ResponseWriter, r *http.Request) {
+	f.changeFollow(w, r, f.fs.WithContext(r.Context()).Create)
+}
+
+// DELETE /api/users/:id/follow
+func (f *Follows) Unfollow(w http.ResponseWriter, r *http.Request) {
+	f.changeFollow(w, r, f.fs.WithContext(r.Context()).Delete)
+}
+
+// GET /api/users/:id/followers?limit=&offset=
+func (f *Follows) Followers(w http.ResponseWriter, r *http.Request) {
+	f.listUsers(w, r, f.fs.WithContext(r.Context()).GetFollowers)
+}
+
+// GET /api/users/:id/following?limit=&offset=
+func (f *Follows) Following(w http.ResponseWriter, r *http.Request) {
+	f.listUsers(w, r, f.fs.WithContext(r.Context()).GetFollowing)
+}
+
+// ------ Helper ------
+
+func (f *Follows) changeFollow(w http.ResponseWriter, r *http.Request, change func(*models.Follow) error) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	followee, err := f.getUserById(w, r)
+	if err != nil {
+		return
+	}
+
+	err = change(&models.Follow{
+		FollowerID: user.ID,
+		FolloweeID: followee.ID,
+	})
+	if err != nil {
+		switch err {
+		case models.ErrFollowSelf:
+			sendErrorResponse(w, http.StatusBadRequest, publicMessage(err))
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Could not update the follow.")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (f *Follows) listUsers(w http.ResponseWriter, r *http.Request, list func(userID uint, limit, offset int) ([]models.User, error)) {
+	user, err := f.getUserById(w, r)
+	if err != nil {
+		return
+	}
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	users, err := list(user.ID, limit, offset)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		return
+	}
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(newPublicUsers(users))
+}
+
+func (f *Follows) getUserById(w http.ResponseWriter, r *http.Request) (*models.User, error) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Invalid User ID.")
+		return nil, err
+	}
+
+	user, err := f.us.WithContext(r.Context()).GetById(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			sendErrorResponse(w, http.StatusNotFound, "User not found.")
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		}
+		return nil, err
+	}
+
+	return user, nil
+}
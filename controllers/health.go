@@ -0,0 +1,110 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"go_rest_pg_starter/email"
+	"go_rest_pg_starter/logging"
+	"go_rest_pg_starter/models"
+)
+
+// How long readiness checks get in total
+const readinessTimeout = 3 * time.Second
+
+type Health struct {
+	services *models.Services
+	emailer  *email.Client
+	// Set once the server starts shutting down
+	shuttingDown int32
+}
+
+type HealthReport struct {
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks,omitempty"`
+}
+
+type HealthCheck struct {
+	Name      string  `json:"name"`
+	Status    string  `json:"status"`
+	LatencyMS float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+func NewHealth(services *models.Services, emailer *email.Client) *Health {
+	return &Health{
+		services: services,
+		emailer:  emailer,
+	}
+}
+
+// ShutDown makes the readiness check fail from now on, so load
+// balancers stop sending requests while in-flight ones drain
+func (h *Health) ShutDown() {
+	atomic.StoreInt32(&h.shuttingDown, 1)
+}
+
+// GET /healthz
+// The process is up and serving requests
+func (h *Health) Live(w http.ResponseWriter, r *http.Request) {
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(HealthReport{Status: "ok"})
+}
+
+// GET /readyz
+// Whether the server can handle requests: the database is
+// reachable and migrated, and emails can be sent
+func (h *Health) Ready(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), readinessTimeout)
+	defer cancel()
+
+	report := HealthReport{
+		Status: "ready",
+		Checks: []HealthCheck{
+			runHealthCheck(ctx, "database", func() error {
+				return h.services.Ping(ctx)
+			}),
+			runHealthCheck(ctx, "migrations", func() error {
+				return h.services.CheckMigrations(ctx)
+			}),
+			runHealthCheck(ctx, "email", h.emailer.CheckConfigured),
+		},
+	}
+	if atomic.LoadInt32(&h.shuttingDown) == 1 {
+		report.Checks = append(report.Checks, HealthCheck{
+			Name:   "shutdown",
+			Status: "failed",
+			Error:  "The server is shutting down.",
+		})
+	}
+
+	status := http.StatusOK
+	for _, check := range report.Checks {
+		if check.Status != "ok" {
+			report.Status = "not_ready"
+			status = http.StatusServiceUnavailable
+		}
+	}
+	setSuccessStatus(w, status)
+	json.NewEncoder(w).Encode(report)
+}
+
+func runHealthCheck(ctx context.Context, name string, check func() error) HealthCheck {
+	start := time.Now()
+	err := check()
+	result := HealthCheck{
+		Name:      name,
+		Status:    "ok",
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+	}
+	// The endpoint is public, details only go to the logs
+	if err != nil {
+		logging.FromContext(ctx).Error("health check failed", "check", name, "error", err)
+		result.Status = "failed"
+		result.Error = "Check failed."
+	}
+	return result
+}
@@ -2,9 +2,14 @@ package controllers
 
 import (
 	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/http"
 )
 
+var errTrailingData = errors.New("controllers: data after the JSON body")
+
 func setSuccessStatus(w http.ResponseWriter, status int) {
 	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
 	w.WriteHeader(status)
@@ -17,3 +22,63 @@ func sendErrorResponse(w http.ResponseWriter, status int, message string) {
 	json.NewEncoder(w).Encode(ErrorMessage{Message: message})
 	return
 }
+
+// Validation errors from the models package carry a message
+// that is safe to show to the client
+type publicError interface {
+	error
+	Public() string
+}
+
+func publicMessage(err error) string {
+	if pErr, ok := err.(publicError); ok {
+		return pErr.Public()
+	}
+	return "Whoops! Something went wrong."
+}
+
+// Responds with the public message of a validation error,
+// or with fallback for any other error
+func sendValidationError(w http.ResponseWriter, err error, fallback string) {
+	if _, ok := err.(publicError); ok {
+		sendErrorResponse(w, http.StatusBadRequest, publicMessage(err))
+		return
+	}
+	sendErrorResponse(w, http.StatusInternalServerError, fallback)
+}
+
+// The address the request came from, without the port
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Decodes the JSON body of the request into v. Fields v does
+// not have, and anything after the JSON value, are errors.
+// An empty body gives io.EOF.
+func decodeJSON(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	err := dec.Decode(v)
+	if err != nil {
+		return err
+	}
+	if dec.Decode(&struct{}{}) != io.EOF {
+		return errTrailingData
+	}
+	return nil
+}
+
+// Responds to a body decodeJSON could not read, with status
+// and message unless the body was over the limit of the route
+func sendDecodeError(w http.ResponseWriter, err error, status int, message string) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		sendErrorResponse(w, http.StatusRequestEntityTooLarge, "Request body is too large.")
+		return
+	}
+	sendErrorResponse(w, status, message)
+}
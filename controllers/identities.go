@@ -0,0 +1,312 @@
+package controllers
+
+import (
+	"crypto/hmac"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"go_rest_pg_starter/middlewares"
+	"go_rest_pg_starter/models"
+	"go_rest_pg_starter/oidc"
+	"go_rest_pg_starter/utils"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	oidcStateCookie = "oidc_state"
+	// How long users have to sign in at the provider
+	oidcStateTTL = 10 * time.Minute
+)
+
+type Identities struct {
+	is        models.IdentityService
+	users     *Users
+	providers map[string]*oidc.Client
+	hmac      utils.HMAC
+}
+
+// oidcState is kept in a signed cookie between sending the user
+// to the provider and them coming back
+type oidcState struct {
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+	// Set when linking to the signed in user
+	UserID uint `json:"user_id,omitempty"`
+	// Set to sign in with a remember token cookie
+	Cookie  bool  `json:"cookie,omitempty"`
+	Expires int64 `json:"expires"`
+}
+
+type AuthorizationURL struct {
+	URL string `json:"authorization_url"`
+}
+
+// NewIdentities signs users in with the given providers, by
+// name. users issues the tokens, like for password logins.
+// stateKey signs the cookie kept while users are away at the
+// provider, and should not be used for anything else.
+func NewIdentities(is models.IdentityService, users *Users, providers map[string]*oidc.Client, stateKey string) *Identities {
+	return &Identities{
+		is:        is,
+		users:     users,
+		providers: providers,
+		hmac:      utils.NewHMAC(stateKey),
+	}
+}
+
+// GET /api/auth/providers
+func (i *Identities) Providers(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(i.providers))
+	for name := range i.providers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(names)
+}
+
+// GET /api/auth/:provider/login?mode=cookie
+// Send the user to the provider to sign in
+func (i *Identities) Login(w http.ResponseWriter, r *http.Request) {
+	authURL, err := i.start(w, r, 0)
+	if err != nil {
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// POST /api/me/identities/:provider
+// Start linking a provider account to the logged in user.
+// The client sends the user to the returned url.
+func (i *Identities) Link(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	authURL, err := i.start(w, r, user.ID)
+	if err != nil {
+		return
+	}
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(AuthorizationURL{URL: authURL})
+}
+
+// GET /api/auth/:provider/callback?code=&state=
+// Where the provider sends the user back. Signs them in, or
+// finishes linking the provider account.
+func (i *Identities) Callback(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["provider"]
+	provider, ok := i.providers[name]
+	if !ok {
+		sendErrorResponse(w, http.StatusNotFound, "Provider not found.")
+		return
+	}
+
+	state, ok := i.readState(r)
+	// The cookie is only good for one try
+	i.clearState(w, r)
+	query := r.URL.Query()
+	if !ok || state.Provider != name || state.State != query.Get("state") {
+		sendErrorResponse(w, http.StatusForbidden, "Sign in session is invalid or has expired.")
+		return
+	}
+	if query.Get("error") != "" || query.Get("code") == "" {
+		sendErrorResponse(w, http.StatusForbidden, "Sign in was cancelled or refused by the provider.")
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		sendErrorResponse(w, http.StatusForbidden, "Could not sign in with the provider.")
+		return
+	}
+	ext := &models.ExternalIdentity{
+		Provider:          name,
+		Subject:           claims.Subject,
+		Email:             claims.Email,
+		EmailVerified:     claims.EmailVerified,
+		PreferredUsername: claims.PreferredUsername,
+		Name:              claims.Name,
+	}
+
+	if state.UserID != 0 {
+		identity, err := i.is.WithContext(r.Context()).Link(state.UserID, ext)
+		if err != nil {
+			sendIdentityError(w, err, "Could not link the account.")
+			return
+		}
+		setSuccessStatus(w, http.StatusCreated)
+		json.NewEncoder(w).Encode(identity)
+		return
+	}
+
+	user, err := i.is.WithContext(r.Context()).SignIn(ext)
+	if err != nil {
+		loginsTotal.Inc("oidc", "failure")
+		sendIdentityError(w, err, "Cannot login.")
+		return
+	}
+
+	signingKey := r.Context().Value("signingKey").(string)
+	if state.Cookie {
+		err = i.users.signInCookie(w, r, user, signingKey)
+	} else {
+		err = i.users.signIn(w, r, user, signingKey)
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Cannot sign-in.")
+		return
+	}
+	loginsTotal.Inc("oidc", "success")
+}
+
+// GET /api/me/identities
+func (i *Identities) List(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	identities, err := i.is.WithContext(r.Context()).GetAllByUserId(user.ID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Could not get the linked accounts.")
+		return
+	}
+	if identities == nil {
+		identities = []models.Identity{}
+	}
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(identities)
+}
+
+// DELETE /api/me/identities/:provider
+// Users without a password can still sign in with a magic link
+func (i *Identities) Unlink(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	err := i.is.WithContext(r.Context()).Delete(user.ID, mux.Vars(r)["provider"])
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			sendErrorResponse(w, http.StatusNotFound, "No account of this provider is linked.")
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Could not unlink the account.")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ------ Helper ------
+
+// Saves a new sign in session to a cookie and returns the
+// provider url to send the user to
+func (i *Identities) start(w http.ResponseWriter, r *http.Request, userID uint) (string, error) {
+	name := mux.Vars(r)["provider"]
+	provider, ok := i.providers[name]
+	if !ok {
+		sendErrorResponse(w, http.StatusNotFound, "Provider not found.")
+		return "", models.ErrNotFound
+	}
+
+	state := oidcState{
+		Provider: name,
+		UserID:   userID,
+		Cookie:   wantsCookie(r),
+		Expires:  time.Now().Add(oidcStateTTL).Unix(),
+	}
+	var challenge string
+	var err error
+	state.State, err = oidc.RandomString()
+	if err == nil {
+		state.Nonce, err = oidc.RandomString()
+	}
+	if err == nil {
+		state.Verifier, challenge, err = oidc.NewPKCE()
+	}
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		return "", err
+	}
+
+	payload, err := json.Marshal(state)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		return "", err
+	}
+	value := base64.RawURLEncoding.EncodeToString(payload)
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    value + "." + i.hmac.Hash(value),
+		Path:     "/api/auth/",
+		MaxAge:   int(oidcStateTTL.Seconds()),
+		HttpOnly: true,
+		Secure:   i.users.secureCookies,
+		// Sent along when the provider redirects back
+		SameSite: http.SameSiteLaxMode,
+	})
+
+	return provider.AuthCodeURL(state.State, state.Nonce, challenge), nil
+}
+
+func (i *Identities) readState(r *http.Request) (*oidcState, bool) {
+	cookie, err := r.Cookie(oidcStateCookie)
+	if err != nil {
+		return nil, false
+	}
+	parts := strings.SplitN(cookie.Value, ".", 2)
+	if len(parts) != 2 || !hmac.Equal([]byte(i.hmac.Hash(parts[0])), []byte(parts[1])) {
+		return nil, false
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, false
+	}
+
+	var state oidcState
+	err = json.Unmarshal(payload, &state)
+	if err != nil || time.Now().Unix() > state.Expires {
+		return nil, false
+	}
+	return &state, true
+}
+
+func (i *Identities) clearState(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    "",
+		Path:     "/api/auth/",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   i.users.secureCookies,
+	})
+}
+
+func sendIdentityError(w http.ResponseWriter, err error, fallback string) {
+	switch err {
+	case models.ErrIdentityTaken, models.ErrIdentityAlreadyLinked, models.ErrIdentityEmailTaken:
+		sendErrorResponse(w, http.StatusConflict, publicMessage(err))
+	case models.ErrIdentityUnverified:
+		sendErrorResponse(w, http.StatusForbidden, publicMessage(err))
+	default:
+		sendValidationError(w, err, fallback)
+	}
+}
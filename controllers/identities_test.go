@@ -0,0 +1,243 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"go_rest_pg_starter/middlewares"
+	"go_rest_pg_starter/models"
+	"go_rest_pg_starter/oidc"
+	"go_rest_pg_starter/oidc/oidctest"
+
+	"github.com/gorilla/mux"
+)
+
+const testRedirectURL = "http://app.test/api/auth/test/callback"
+
+// Records the identities signed in or linked, instead of
+// storing them
+type fakeIdentityService struct {
+	models.IdentityService
+	signedIn *models.ExternalIdentity
+	linked   *models.ExternalIdentity
+	linkedTo uint
+}
+
+func (fis *fakeIdentityService) WithContext(context.Context) models.IdentityService {
+	return fis
+}
+
+func (fis *fakeIdentityService) SignIn(ext *models.ExternalIdentity) (*models.User, error) {
+	fis.signedIn = ext
+	user := &models.User{Username: "alice", Email: ext.Email}
+	user.ID = 7
+	return user, nil
+}
+
+func (fis *fakeIdentityService) Link(userID uint, ext *models.ExternalIdentity) (*models.Identity, error) {
+	fis.linked = ext
+	fis.linkedTo = userID
+	return &models.Identity{UserID: userID, Provider: ext.Provider, Subject: ext.Subject}, nil
+}
+
+type fakeSessionService struct {
+	models.SessionService
+	err error
+}
+
+func (fss *fakeSessionService) WithContext(context.Context) models.SessionService {
+	return fss
+}
+
+func (fss *fakeSessionService) Create(user *models.User, userAgent, ip string, expiresAt time.Time) (*models.Session, error) {
+	if fss.err != nil {
+		return nil, fss.err
+	}
+	return &models.Session{ID: 1, UserID: user.ID, ExpiresAt: expiresAt}, nil
+}
+
+type identitiesTest struct {
+	provider   *oidctest.Provider
+	identities *fakeIdentityService
+	sessions   *fakeSessionService
+	router     *mux.Router
+}
+
+func newIdentitiesTest(t *testing.T) *identitiesTest {
+	t.Helper()
+	provider := oidctest.NewProvider("client", "secret")
+	t.Cleanup(provider.Close)
+	provider.SetUser(oidctest.User{
+		Subject:       "alice-subject",
+		Email:         "alice@example.com",
+		EmailVerified: true,
+	})
+	client, err := oidc.NewClient(context.Background(), oidc.Config{
+		Issuer:       provider.Issuer(),
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  testRedirectURL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	it := &identitiesTest{
+		provider:   provider,
+		identities: &fakeIdentityService{},
+		sessions:   &fakeSessionService{},
+		router:     mux.NewRouter(),
+	}
+	users := NewUsers(nil, it.sessions, nil, nil, true)
+	ctrl := NewIdentities(it.identities, users, map[string]*oidc.Client{"test": client}, "state-key")
+	r := it.router.PathPrefix("/api").Subrouter()
+	r.HandleFunc("/auth/{provider}/login", ctrl.Login).Methods("GET")
+	r.HandleFunc("/auth/{provider}/callback", ctrl.Callback).Methods("GET")
+	r.HandleFunc("/me/identities/{provider}", ctrl.Link).Methods("POST")
+	return it
+}
+
+// Serves the request as the app would, signed in as userID
+// unless it is 0
+func (it *identitiesTest) serve(req *http.Request, userID uint) *httptest.ResponseRecorder {
+	ctx := context.WithValue(req.Context(), "signingKey", "signing-key")
+	if userID != 0 {
+		ctx = context.WithValue(ctx, "logged_in_user", &middlewares.UserWithToken{ID: userID})
+	}
+	rec := httptest.NewRecorder()
+	it.router.ServeHTTP(rec, req.WithContext(ctx))
+	return rec
+}
+
+// Signs in at the provider, and returns the request of the
+// provider sending the user back along with the state cookie
+func (it *identitiesTest) callback(t *testing.T, authURL string, cookies []*http.Cookie) *http.Request {
+	t.Helper()
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil || res.StatusCode != http.StatusFound {
+		t.Fatalf("provider answered %d, %v", res.StatusCode, err)
+	}
+
+	req := httptest.NewRequest("GET", location.RequestURI(), nil)
+	for _, cookie := range cookies {
+		req.AddCookie(cookie)
+	}
+	return req
+}
+
+func TestIdentitiesSignIn(t *testing.T) {
+	it := newIdentitiesTest(t)
+
+	rec := it.serve(httptest.NewRequest("GET", "/api/auth/test/login", nil), 0)
+	if rec.Code != http.StatusFound {
+		t.Fatalf("login status = %d, want %d", rec.Code, http.StatusFound)
+	}
+	req := it.callback(t, rec.Header().Get("Location"), rec.Result().Cookies())
+
+	rec = it.serve(req, 0)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("callback status = %d, want %d: %s", rec.Code, http.StatusOK, rec.Body)
+	}
+	var response UserWithToken
+	json.NewDecoder(rec.Body).Decode(&response)
+	if response.Token == "" || response.UserID != 7 {
+		t.Errorf("callback response = %+v, want a token for user 7", response)
+	}
+	ext := it.identities.signedIn
+	if ext == nil || ext.Provider != "test" || ext.Subject != "alice-subject" || !ext.EmailVerified {
+		t.Errorf("signed in %+v, want alice-subject of test", ext)
+	}
+}
+
+func TestIdentitiesLink(t *testing.T) {
+	it := newIdentitiesTest(t)
+
+	rec := it.serve(httptest.NewRequest("POST", "/api/me/identities/test", nil), 3)
+	if rec.Code != http.StatusOK {
+		t.Fatalf("link status = %d, want %d", rec.Code, http.StatusOK)
+	}
+	var authURL AuthorizationURL
+	json.NewDecoder(rec.Body).Decode(&authURL)
+	req := it.callback(t, authURL.URL, rec.Result().Cookies())
+
+	// The state cookie carries who is linking
+	rec = it.serve(req, 0)
+	if rec.Code != http.StatusCreated {
+		t.Fatalf("callback status = %d, want %d: %s", rec.Code, http.StatusCreated, rec.Body)
+	}
+	if it.identities.linked == nil || it.identities.linkedTo != 3 {
+		t.Errorf("linked %+v to %d, want alice-subject to 3", it.identities.linked, it.identities.linkedTo)
+	}
+	if it.identities.signedIn != nil {
+		t.Error("linking signed in")
+	}
+}
+
+func TestIdentitiesCallbackRefused(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(req *http.Request) *http.Request
+		want   int
+	}{
+		{"no state cookie", func(req *http.Request) *http.Request {
+			req.Header.Del("Cookie")
+			return req
+		}, http.StatusForbidden},
+		{"other state", func(req *http.Request) *http.Request {
+			q := req.URL.Query()
+			q.Set("state", "other")
+			req.URL.RawQuery = q.Encode()
+			return req
+		}, http.StatusForbidden},
+		{"other code", func(req *http.Request) *http.Request {
+			q := req.URL.Query()
+			q.Set("code", "other")
+			req.URL.RawQuery = q.Encode()
+			return req
+		}, http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it := newIdentitiesTest(t)
+			rec := it.serve(httptest.NewRequest("GET", "/api/auth/test/login", nil), 0)
+			req := it.callback(t, rec.Header().Get("Location"), rec.Result().Cookies())
+
+			rec = it.serve(tt.change(req), 0)
+			if rec.Code != tt.want {
+				t.Errorf("callback status = %d, want %d", rec.Code, tt.want)
+			}
+			if it.identities.signedIn != nil {
+				t.Error("signed in")
+			}
+		})
+	}
+}
+
+func TestIdentitiesSignInFails(t *testing.T) {
+	it := newIdentitiesTest(t)
+	it.sessions.err = errors.New("database is down")
+
+	rec := it.serve(httptest.NewRequest("GET", "/api/auth/test/login", nil), 0)
+	req := it.callback(t, rec.Header().Get("Location"), rec.Result().Cookies())
+
+	rec = it.serve(req, 0)
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("callback status = %d, want %d", rec.Code, http.StatusInternalServerError)
+	}
+}
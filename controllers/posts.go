@@ -1,9 +1,14 @@
 package controllers
 
 import (
+	"context"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"time"
 
 	"go_rest_pg_starter/middlewares"
 	"go_rest_pg_starter/models"
@@ -13,16 +18,35 @@ import (
 
 type Posts struct {
 	ps models.PostService
+	us models.UserService
 }
 
 type PostFormat struct {
-	Title       string `schema:"title"`
-	Description string `schema:"description"`
+	Title       string     `schema:"title"`
+	Description string     `schema:"description"`
+	Status      string     `schema:"status"`
+	PublishAt   *time.Time `schema:"publish_at" json:"publish_at"`
 }
 
-func NewPosts(ps models.PostService) *Posts {
+// Post as returned by GetOne, with its reaction counts and
+// the reactions of the logged in user
+type PostWithReactions struct {
+	*models.Post
+	Reactions   map[string]int `json:"reactions"`
+	MyReactions []string       `json:"my_reactions"`
+}
+
+// A page of the feed. Pass NextCursor as the cursor
+// query param to get the next page.
+type FeedPage struct {
+	Posts      []models.Post `json:"posts"`
+	NextCursor string        `json:"next_cursor,omitempty"`
+}
+
+func NewPosts(ps models.PostService, us models.UserService) *Posts {
 	return &Posts{
 		ps: ps,
+		us: us,
 	}
 }
 
@@ -35,9 +59,9 @@ func (ps *Posts) Create(w http.ResponseWriter, r *http.Request) {
 
 	// Get user input for creating a post
 	var postFormat PostFormat
-	err := json.NewDecoder(r.Body).Decode(&postFormat)
+	err := decodeJSON(r, &postFormat)
 	if err != nil {
-		sendErrorResponse(w, http.StatusBadRequest, "Cannot create a post.")
+		sendDecodeError(w, err, http.StatusBadRequest, "Cannot create a post.")
 		return
 	}
 
@@ -45,12 +69,19 @@ func (ps *Posts) Create(w http.ResponseWriter, r *http.Request) {
 		UserID:      user.ID,
 		Title:       postFormat.Title,
 		Description: postFormat.Description,
+		Status:      postFormat.Status,
+		PublishAt:   postFormat.PublishAt,
 	}
 
 	// Create a post
-	err = ps.ps.Create(&post)
+	err = ps.ps.WithContext(r.Context()).Create(&post)
 	if err != nil {
-		sendErrorResponse(w, http.StatusBadRequest, "Failed to create a post.")
+		switch err {
+		case models.ErrStatusInvalid, models.ErrPublishAtRequired:
+			sendErrorResponse(w, http.StatusBadRequest, publicMessage(err))
+		default:
+			sendErrorResponse(w, http.StatusBadRequest, "Failed to create a post.")
+		}
 		return
 	}
 
@@ -64,8 +95,24 @@ func (p *Posts) GetOne(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// Drafts, scheduled and archived posts are only visible to their author
+	var userID uint
+	if user := middlewares.LookUpUserFromContext(r.Context()); user != nil {
+		userID = user.ID
+	}
+	if !post.IsVisibleTo(userID) {
+		sendErrorResponse(w, http.StatusNotFound, "Post not found.")
+		return
+	}
+
+	response, err := p.withReactions(r.Context(), post, userID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		return
+	}
+
 	setSuccessStatus(w, http.StatusOK)
-	json.NewEncoder(w).Encode(post)
+	json.NewEncoder(w).Encode(response)
 }
 
 func (p *Posts) Update(w http.ResponseWriter, r *http.Request) {
@@ -86,15 +133,17 @@ func (p *Posts) Update(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var postFormat PostFormat
-	err = json.NewDecoder(r.Body).Decode(&postFormat)
+	err = decodeJSON(r, &postFormat)
 	if err != nil {
-		sendErrorResponse(w, http.StatusBadRequest, "Cannot update the post.")
+		sendDecodeError(w, err, http.StatusBadRequest, "Cannot update the post.")
 		return
 	}
 
 	updatingPost := models.Post{
 		Title:       postFormat.Title,
 		Description: postFormat.Description,
+		Status:      postFormat.Status,
+		PublishAt:   postFormat.PublishAt,
 	}
 	if updatingPost.Title != "" {
 		post.Title = updatingPost.Title
@@ -102,10 +151,21 @@ func (p *Posts) Update(w http.ResponseWriter, r *http.Request) {
 	if updatingPost.Description != "" {
 		post.Description = updatingPost.Description
 	}
+	if updatingPost.Status != "" {
+		post.Status = updatingPost.Status
+	}
+	if updatingPost.PublishAt != nil {
+		post.PublishAt = updatingPost.PublishAt
+	}
 
-	err = p.ps.Update(post)
+	err = p.ps.WithContext(r.Context()).Update(post)
 	if err != nil {
-		sendErrorResponse(w, http.StatusInternalServerError, "Could not update the post.")
+		switch err {
+		case models.ErrStatusInvalid, models.ErrPublishAtRequired:
+			sendErrorResponse(w, http.StatusBadRequest, publicMessage(err))
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Could not update the post.")
+		}
 		return
 	}
 
@@ -129,14 +189,230 @@ func (p *Posts) Delete(w http.ResponseWriter, r *http.Request) {
 		sendErrorResponse(w, http.StatusForbidden, "You do not have permission.")
 		return
 	}
-	err = p.ps.Delete(post.ID)
+	err = p.ps.WithContext(r.Context()).Delete(post.ID)
 	if err != nil {
 		sendErrorResponse(w, http.StatusInternalServerError, "Could not delete the post.")
 		return
 	}
 }
 
+// GET /api/me/trash
+// Lists the posts the user has deleted and can still restore
+func (p *Posts) Trash(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	posts, err := p.ps.WithContext(r.Context()).GetDeletedByUserId(user.ID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Could not get deleted posts.")
+		return
+	}
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(posts)
+}
+
+// POST /api/posts/:id/restore
+func (p *Posts) Restore(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Invalid Post ID.")
+		return
+	}
+
+	post, err := p.ps.WithContext(r.Context()).GetDeletedById(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			sendErrorResponse(w, http.StatusNotFound, "Post not found in trash.")
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		}
+		return
+	}
+
+	if post.UserID != user.ID {
+		sendErrorResponse(w, http.StatusForbidden, "You do not have permission.")
+		return
+	}
+
+	err = p.ps.WithContext(r.Context()).Restore(post.ID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Could not restore the post.")
+		return
+	}
+	post.DeletedAt = nil
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(post)
+}
+
+// GET /api/users/:username/posts?limit=&offset=
+// Published posts of the user, or all of them for the user themselves
+func (p *Posts) ByUser(w http.ResponseWriter, r *http.Request) {
+	author, err := p.us.WithContext(r.Context()).GetByUsername(mux.Vars(r)["username"])
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			sendErrorResponse(w, http.StatusNotFound, "User not found.")
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		}
+		return
+	}
+
+	user := middlewares.LookUpUserFromContext(r.Context())
+	publishedOnly := user == nil || user.ID != author.ID
+
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+	offset, _ := strconv.Atoi(r.URL.Query().Get("offset"))
+	posts, err := p.ps.WithContext(r.Context()).GetPageByUserId(author.ID, publishedOnly, limit, offset)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Could not get the posts.")
+		return
+	}
+	if posts == nil {
+		posts = []models.Post{}
+	}
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(posts)
+}
+
+// GET /api/feed?cursor=&limit=
+// Posts from the users the logged in user follows, newest first
+func (p *Posts) Feed(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	var cursor *models.FeedCursor
+	if encoded := r.URL.Query().Get("cursor"); encoded != "" {
+		var err error
+		cursor, err = decodeFeedCursor(encoded)
+		if err != nil {
+			sendErrorResponse(w, http.StatusBadRequest, "Invalid cursor.")
+			return
+		}
+	}
+	limit, _ := strconv.Atoi(r.URL.Query().Get("limit"))
+
+	posts, err := p.ps.WithContext(r.Context()).GetFeed(user.ID, cursor, limit)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Could not get the feed.")
+		return
+	}
+
+	page := FeedPage{Posts: posts}
+	if page.Posts == nil {
+		page.Posts = []models.Post{}
+	}
+	if len(posts) > 0 {
+		last := posts[len(posts)-1]
+		// Published posts have a publish time, see AutoMigrate
+		publishAt := last.CreatedAt
+		if last.PublishAt != nil {
+			publishAt = *last.PublishAt
+		}
+		page.NextCursor = encodeFeedCursor(&models.FeedCursor{
+			PublishAt: publishAt,
+			ID:        last.ID,
+		})
+	}
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(page)
+}
+
+// PUT /api/posts/:id/reactions/:kind
+func (p *Posts) React(w http.ResponseWriter, r *http.Request) {
+	p.changeReaction(w, r, p.ps.WithContext(r.Context()).React)
+}
+
+// DELETE /api/posts/:id/reactions/:kind
+func (p *Posts) Unreact(w http.ResponseWriter, r *http.Request) {
+	p.changeReaction(w, r, p.ps.WithContext(r.Context()).Unreact)
+}
+
 // ------ Helper ------
+
+// Adds or removes the reaction in the url and responds with
+// the post and its updated reactions
+func (p *Posts) changeReaction(w http.ResponseWriter, r *http.Request, change func(postID, userID uint, kind string) error) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	post, err := p.getPostById(w, r)
+	if err != nil {
+		return
+	}
+
+	if !post.IsVisibleTo(user.ID) {
+		sendErrorResponse(w, http.StatusNotFound, "Post not found.")
+		return
+	}
+
+	err = change(post.ID, user.ID, mux.Vars(r)["kind"])
+	if err != nil {
+		switch err {
+		case models.ErrReactionKindInvalid:
+			sendErrorResponse(w, http.StatusBadRequest, publicMessage(err))
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Could not update the reaction.")
+		}
+		return
+	}
+
+	// Reload the post to get the updated counters
+	post, err = p.ps.WithContext(r.Context()).GetOneById(post.ID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		return
+	}
+
+	response, err := p.withReactions(r.Context(), post, user.ID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		return
+	}
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (p *Posts) withReactions(ctx context.Context, post *models.Post, userID uint) (*PostWithReactions, error) {
+	response := &PostWithReactions{
+		Post:        post,
+		Reactions:   post.ReactionCounts(),
+		MyReactions: []string{},
+	}
+	if userID == 0 {
+		return response, nil
+	}
+
+	kinds, err := p.ps.WithContext(ctx).GetReactionKinds(post.ID, userID)
+	if err != nil {
+		return nil, err
+	}
+	if kinds != nil {
+		response.MyReactions = kinds
+	}
+	return response, nil
+}
 func (p *Posts) getPostById(w http.ResponseWriter, r *http.Request) (*models.Post, error) {
 	// Get :id from url id param, converted from string to int
 	vars := mux.Vars(r)
@@ -147,7 +423,7 @@ func (p *Posts) getPostById(w http.ResponseWriter, r *http.Request) (*models.Pos
 		return nil, err
 	}
 
-	post, err := p.ps.GetOneById(uint(id))
+	post, err := p.ps.WithContext(r.Context()).GetOneById(uint(id))
 	if err != nil {
 		switch err {
 		case models.ErrNotFound:
@@ -160,3 +436,30 @@ func (p *Posts) getPostById(w http.ResponseWriter, r *http.Request) (*models.Pos
 
 	return post, nil
 }
+
+// Cursors are opaque to clients: "<publish time in unix nanoseconds>:<post id>"
+// base64 URL encoded
+func encodeFeedCursor(cursor *models.FeedCursor) string {
+	raw := fmt.Sprintf("%d:%d", cursor.PublishAt.UnixNano(), cursor.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+func decodeFeedCursor(encoded string) (*models.FeedCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	var nanos int64
+	var id uint
+	n, err := fmt.Sscanf(string(raw), "%d:%d", &nanos, &id)
+	if err != nil {
+		return nil, err
+	}
+	if n != 2 || id == 0 {
+		return nil, errors.New("controllers: malformed feed cursor")
+	}
+	return &models.FeedCursor{
+		PublishAt: time.Unix(0, nanos),
+		ID:        id,
+	}, nil
+}
@@ -0,0 +1,93 @@
+package controllers
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"go_rest_pg_starter/middlewares"
+	"go_rest_pg_starter/models"
+
+	"github.com/gorilla/mux"
+)
+
+type Sessions struct {
+	ss models.SessionService
+}
+
+// SessionInfo describes a signed in device of the user
+type SessionInfo struct {
+	ID         uint      `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastSeenAt time.Time `json:"last_seen_at"`
+	// Whether the request was made with this session's token
+	Current bool `json:"current"`
+}
+
+func NewSessions(ss models.SessionService) *Sessions {
+	return &Sessions{
+		ss: ss,
+	}
+}
+
+// GET /api/me/sessions
+func (s *Sessions) List(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	sessions, err := s.ss.WithContext(r.Context()).List(user.ID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Could not get the sessions.")
+		return
+	}
+
+	infos := make([]SessionInfo, 0, len(sessions))
+	for _, session := range sessions {
+		infos = append(infos, SessionInfo{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastSeenAt: session.LastSeenAt,
+			Current:    session.ID == user.SessionID,
+		})
+	}
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(infos)
+}
+
+// DELETE /api/me/sessions/:id
+// Revoking the current session signs the request's token out
+func (s *Sessions) Revoke(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusBadRequest, "Invalid session id.")
+		return
+	}
+
+	err = s.ss.WithContext(r.Context()).Revoke(user.ID, uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			sendErrorResponse(w, http.StatusNotFound, "Session not found.")
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Could not revoke the session.")
+		}
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -1,29 +1,99 @@
 package controllers
 
 import (
+	"context"
 	"encoding/json"
 	"net/http"
+	"strconv"
+	"time"
 
 	"go_rest_pg_starter/auth"
 	"go_rest_pg_starter/email"
+	"go_rest_pg_starter/jobs"
+	"go_rest_pg_starter/logging"
+	"go_rest_pg_starter/metrics"
 	"go_rest_pg_starter/middlewares"
 	"go_rest_pg_starter/models"
+
+	"github.com/gorilla/mux"
 )
 
+var loginsTotal = metrics.NewCounterVec("logins_total",
+	"Sign in attempts, by method and result.", "method", "result")
+
 type UserWithToken struct {
 	UserID    uint   `json:"user_id"`
 	Username  string `json:"username"`
 	UserEmail string `json:"user_email"`
-	Token     string `json:"token"`
+	Token     string `json:"token,omitempty"`
+	// Set instead of Token when signed in with a cookie
+	CSRFToken string `json:"csrf_token,omitempty"`
 }
 
 type ErrorMessage struct {
 	Message string `json:"message"`
 }
 
+// PublicUser is the part of a user shown in lists of users
+type PublicUser struct {
+	ID          uint   `json:"id"`
+	Username    string `json:"username"`
+	DisplayName string `json:"display_name"`
+	AvatarURL   string `json:"avatar_url"`
+}
+
+func newPublicUsers(users []models.User) []PublicUser {
+	publicUsers := make([]PublicUser, 0, len(users))
+	for _, user := range users {
+		publicUsers = append(publicUsers, PublicUser{
+			ID:          user.ID,
+			Username:    user.Username,
+			DisplayName: user.DisplayName,
+			AvatarURL:   user.AvatarURL,
+		})
+	}
+	return publicUsers
+}
+
+// Profile is the public profile of a user
+type Profile struct {
+	PublicUser
+	Bio       string    `json:"bio"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+func newProfile(user *models.User) Profile {
+	return Profile{
+		PublicUser: PublicUser{
+			ID:          user.ID,
+			Username:    user.Username,
+			DisplayName: user.DisplayName,
+			AvatarURL:   user.AvatarURL,
+		},
+		Bio:       user.Bio,
+		CreatedAt: user.CreatedAt,
+	}
+}
+
+// MyProfile is the profile of the logged in user, including
+// private fields only they can see
+type MyProfile struct {
+	Profile
+	Email string `json:"email"`
+	// Set while a new email address waits to be confirmed
+	PendingEmail string `json:"pending_email,omitempty"`
+	// Set when only part of an update went through
+	Message string `json:"message,omitempty"`
+}
+
 type Users struct {
 	us      models.UserService
+	ss      models.SessionService
 	emailer *email.Client
+	// Sends emails that must not slow down responses
+	queue *jobs.Queue
+	// Whether cookies are only sent back over HTTPS
+	secureCookies bool
 }
 
 type SignupUser struct {
@@ -37,16 +107,46 @@ type LoginUser struct {
 	Password string `schema:"password"`
 }
 
+// Fields left out of the request are not changed
+type UpdateProfileUser struct {
+	Username    *string `schema:"username"`
+	DisplayName *string `schema:"display_name" json:"display_name"`
+	Bio         *string `schema:"bio"`
+	AvatarURL   *string `schema:"avatar_url" json:"avatar_url"`
+	Email       *string `schema:"email"`
+}
+
+type ConfirmEmailUser struct {
+	Token string `schema:"token"`
+}
+
+type ChangePasswordUser struct {
+	CurrentPassword string `schema:"current_password" json:"current_password"`
+	NewPassword     string `schema:"new_password" json:"new_password"`
+}
+
+type MagicLoginUser struct {
+	Email string `schema:"email"`
+	Token string `schema:"token"`
+}
+
 type ResetPasswordUser struct {
 	Email    string `schema:"email"`
 	Token    string `schema:"token"`
 	Password string `schema:"password"`
 }
 
-func NewUsers(us models.UserService, emailer *email.Client) *Users {
+// NewUsers signs users in with JWTs or remember token cookies.
+// Cookies are marked Secure when secureCookies is set, which is
+// needed behind proxies ending TLS, where requests come in over
+// plain HTTP.
+func NewUsers(us models.UserService, ss models.SessionService, emailer *email.Client, queue *jobs.Queue, secureCookies bool) *Users {
 	return &Users{
-		us:      us,
-		emailer: emailer,
+		us:            us,
+		ss:            ss,
+		emailer:       emailer,
+		queue:         queue,
+		secureCookies: secureCookies,
 	}
 }
 
@@ -54,9 +154,9 @@ func NewUsers(us models.UserService, emailer *email.Client) *Users {
 func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 	var signupUser SignupUser
 
-	err := json.NewDecoder(r.Body).Decode(&signupUser)
+	err := decodeJSON(r, &signupUser)
 	if err != nil {
-		sendErrorResponse(w, http.StatusForbidden, "Cannot signup.")
+		sendDecodeError(w, err, http.StatusForbidden, "Cannot signup.")
 		return
 	}
 
@@ -67,14 +167,14 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Create the user in our database
-	err = u.us.Create(&user)
+	err = u.us.WithContext(r.Context()).Create(&user)
 	if err != nil {
-		sendErrorResponse(w, http.StatusForbidden, "Cannot signup.")
+		sendValidationError(w, err, "Cannot signup.")
 		return
 	}
 
 	// Send an email to the user
-	err = u.emailer.Welcome(user.Username, user.Email)
+	err = u.emailer.Welcome(r.Context(), user.Username, user.Email)
 	if err != nil {
 		sendErrorResponse(w, http.StatusFound, "Could not send welcome email.")
 		return
@@ -82,7 +182,7 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 
 	// Issue JWT and let the user sign-in
 	signingKey := r.Context().Value("signingKey").(string)
-	err = u.signIn(w, &user, signingKey)
+	err = u.signIn(w, r, &user, signingKey)
 	if err != nil {
 		sendErrorResponse(w, http.StatusFound, "Cannot signin.")
 		return
@@ -93,14 +193,15 @@ func (u *Users) Create(w http.ResponseWriter, r *http.Request) {
 func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 	var loginUser LoginUser
 
-	err := json.NewDecoder(r.Body).Decode(&loginUser)
+	err := decodeJSON(r, &loginUser)
 	if err != nil {
-		sendErrorResponse(w, http.StatusForbidden, "Cannot login.")
+		sendDecodeError(w, err, http.StatusForbidden, "Cannot login.")
 		return
 	}
 
-	user, err := u.us.Authenticate(loginUser.Email, loginUser.Password)
+	user, err := u.us.WithContext(r.Context()).Authenticate(loginUser.Email, loginUser.Password)
 	if err != nil {
+		loginsTotal.Inc("password", "failure")
 		switch err {
 		case models.ErrNotFound:
 			sendErrorResponse(w, http.StatusNotFound, "User not found.")
@@ -111,16 +212,103 @@ func (u *Users) Login(w http.ResponseWriter, r *http.Request) {
 	}
 
 	signingKey := r.Context().Value("signingKey").(string)
-	err = u.signIn(w, user, signingKey)
+	err = u.signIn(w, r, user, signingKey)
 	if err != nil {
 		sendErrorResponse(w, http.StatusFound, "Cannot sign-in.")
 		return
 	}
+	loginsTotal.Inc("password", "success")
+}
+
+// POST /api/login/magic
+// Email the user a link to sign in without their password.
+// Like InitiateReset, it does not tell whether the email
+// belongs to a user, and does the work in the background.
+func (u *Users) InitiateMagicLogin(w http.ResponseWriter, r *http.Request) {
+	var magicLoginUser MagicLoginUser
+
+	err := decodeJSON(r, &magicLoginUser)
+	if err != nil {
+		sendDecodeError(w, err, http.StatusBadRequest, "Cannot get the email.")
+		return
+	}
+
+	toEmail := magicLoginUser.Email
+	logger := logging.FromContext(r.Context())
+	err = u.queue.Enqueue(func(ctx context.Context) {
+		token, err := u.us.WithContext(ctx).InitiateMagicLogin(toEmail)
+		switch err {
+		case nil:
+			err = u.emailer.MagicLink(ctx, toEmail, token)
+			if err != nil {
+				logger.Error("could not email the magic link", "error", err)
+			}
+		case models.ErrNotFound:
+		default:
+			logger.Error("could not issue a magic link", "error", err)
+		}
+	})
+	if err != nil {
+		sendErrorResponse(w, http.StatusServiceUnavailable, "Cannot send a sign in link right now, please try again later.")
+		return
+	}
+
+	setSuccessStatus(w, http.StatusAccepted)
+	json.NewEncoder(w).Encode(ErrorMessage{Message: "If an account uses this email, we sent it a link to sign in."})
 }
 
-// Set token if the user doesn't have one and set it to cookie
-func (u *Users) signIn(w http.ResponseWriter, user *models.User, signingKey string) error {
-	tokenString, err := auth.IssueJWT(user, signingKey)
+// POST /api/login/magic/verify
+// Exchange the emailed token for a JWT. Only POST consumes it,
+// so that link scanners following the emailed link do not.
+func (u *Users) CompleteMagicLogin(w http.ResponseWriter, r *http.Request) {
+	var magicLoginUser MagicLoginUser
+
+	err := decodeJSON(r, &magicLoginUser)
+	if err != nil {
+		sendDecodeError(w, err, http.StatusBadRequest, "Cannot get the token.")
+		return
+	}
+
+	user, err := u.us.WithContext(r.Context()).CompleteMagicLogin(magicLoginUser.Token)
+	if err != nil {
+		loginsTotal.Inc("magic_link", "failure")
+		switch err {
+		case models.ErrTokenInvalid:
+			sendErrorResponse(w, http.StatusForbidden, publicMessage(err))
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Cannot login.")
+		}
+		return
+	}
+
+	signingKey := r.Context().Value("signingKey").(string)
+	err = u.signIn(w, r, user, signingKey)
+	if err != nil {
+		sendErrorResponse(w, http.StatusFound, "Cannot sign-in.")
+		return
+	}
+	loginsTotal.Inc("magic_link", "success")
+}
+
+// Signs the user in with a bearer JWT, or with a remember token
+// cookie when the client asks for it with ?mode=cookie or was
+// signed in with one already.
+func (u *Users) signIn(w http.ResponseWriter, r *http.Request, user *models.User, signingKey string) error {
+	if wantsCookie(r) {
+		return u.signInCookie(w, r, user, signingKey)
+	}
+	return u.signInBearer(w, r, user, signingKey)
+}
+
+// Each JWT issued is recorded as a session of the device
+// making the request, so it can be listed and revoked.
+func (u *Users) signInBearer(w http.ResponseWriter, r *http.Request, user *models.User, signingKey string) error {
+	session, err := u.ss.WithContext(r.Context()).Create(user, r.UserAgent(), clientIP(r), time.Now().Add(auth.TokenTTL))
+	if err != nil {
+		return err
+	}
+
+	tokenString, err := auth.IssueJWT(user, session.ID, signingKey)
 
 	if err != nil {
 		return err
@@ -141,6 +329,78 @@ func (u *Users) signIn(w http.ResponseWriter, user *models.User, signingKey stri
 	return nil
 }
 
+// Sets the remember token cookie of a new session, so each
+// browser is listed and revoked like the devices of bearer
+// tokens. The CSRF token is both set as a cookie and returned.
+func (u *Users) signInCookie(w http.ResponseWriter, r *http.Request, user *models.User, signingKey string) error {
+	session, err := u.ss.WithContext(r.Context()).Remember(user, r.UserAgent(), clientIP(r), time.Now().Add(auth.RememberTTL))
+	if err != nil {
+		return err
+	}
+
+	csrfToken := auth.CSRFToken(session.Token, signingKey)
+	u.setRememberCookies(w, session.Token, csrfToken, int(auth.RememberTTL.Seconds()))
+
+	response := UserWithToken{
+		UserID:    user.ID,
+		UserEmail: user.Email,
+		Username:  user.Username,
+		CSRFToken: csrfToken,
+	}
+	setSuccessStatus(w, http.StatusOK)
+	return json.NewEncoder(w).Encode(response)
+}
+
+// POST /api/logout
+// Signs out the session of the remember token cookie, or of the
+// bearer token
+func (u *Users) Logout(w http.ResponseWriter, r *http.Request) {
+	user := middlewares.LookUpUserFromContext(r.Context())
+	if user == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	err := u.ss.WithContext(r.Context()).Revoke(user.ID, user.SessionID)
+	if err != nil && err != models.ErrNotFound {
+		sendErrorResponse(w, http.StatusInternalServerError, "Could not sign out.")
+		return
+	}
+
+	u.setRememberCookies(w, "", "", -1)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func wantsCookie(r *http.Request) bool {
+	if r.URL.Query().Get("mode") == "cookie" {
+		return true
+	}
+	user := middlewares.LookUpUserFromContext(r.Context())
+	return user != nil && user.ViaCookie
+}
+
+// Only the CSRF cookie can be read by scripts. A negative maxAge
+// deletes both cookies.
+func (u *Users) setRememberCookies(w http.ResponseWriter, token, csrfToken string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.RememberCookie,
+		Value:    token,
+		Path:     "/api",
+		MaxAge:   maxAge,
+		HttpOnly: true,
+		Secure:   u.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     auth.CSRFCookie,
+		Value:    csrfToken,
+		Path:     "/api",
+		MaxAge:   maxAge,
+		Secure:   u.secureCookies,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
 // GET /api/me
 func (u *Users) Me(w http.ResponseWriter, r *http.Request) {
 	user := middlewares.LookUpUserFromContext(r.Context())
@@ -153,57 +413,304 @@ func (u *Users) Me(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(user)
 }
 
+// GET /api/users/:username
+func (u *Users) Show(w http.ResponseWriter, r *http.Request) {
+	user, err := u.us.WithContext(r.Context()).GetByUsername(mux.Vars(r)["username"])
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			sendErrorResponse(w, http.StatusNotFound, "User not found.")
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		}
+		return
+	}
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(newProfile(user))
+}
+
+// PATCH /api/me
+// Update the profile of the logged in user. A new email
+// address only replaces the current one once it is confirmed.
+func (u *Users) UpdateMe(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := middlewares.LookUpUserFromContext(r.Context())
+	if loggedInUser == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	var updateProfileUser UpdateProfileUser
+	err := decodeJSON(r, &updateProfileUser)
+	if err != nil {
+		sendDecodeError(w, err, http.StatusBadRequest, "Cannot update the profile.")
+		return
+	}
+
+	user, err := u.us.WithContext(r.Context()).GetById(loggedInUser.ID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "User not found.")
+		return
+	}
+
+	if updateProfileUser.Username != nil {
+		user.Username = *updateProfileUser.Username
+	}
+	if updateProfileUser.DisplayName != nil {
+		user.DisplayName = *updateProfileUser.DisplayName
+	}
+	if updateProfileUser.Bio != nil {
+		user.Bio = *updateProfileUser.Bio
+	}
+	if updateProfileUser.AvatarURL != nil {
+		user.AvatarURL = *updateProfileUser.AvatarURL
+	}
+
+	// The new email is checked before anything is saved, so that
+	// an invalid one leaves the profile as it was
+	changesEmail := updateProfileUser.Email != nil && *updateProfileUser.Email != user.Email
+	var emailToken string
+	if changesEmail {
+		emailToken, err = u.us.WithContext(r.Context()).InitiateEmailChange(user, *updateProfileUser.Email)
+		if err != nil {
+			sendValidationError(w, err, "Cannot change the email.")
+			return
+		}
+	}
+
+	err = u.us.WithContext(r.Context()).Update(user)
+	if err != nil {
+		if changesEmail {
+			u.cancelEmailChange(r, user.ID)
+		}
+		sendValidationError(w, err, "Cannot update the profile.")
+		return
+	}
+
+	response := MyProfile{
+		Profile: newProfile(user),
+		Email:   user.Email,
+	}
+
+	if changesEmail {
+		err = u.emailer.VerifyEmailChange(r.Context(), *updateProfileUser.Email, emailToken)
+		if err != nil {
+			// The rest of the profile is saved, the change of
+			// email is not
+			u.cancelEmailChange(r, user.ID)
+			logging.FromContext(r.Context()).Error("could not email the new address", "error", err)
+			response.Message = "The profile was updated, but the email could not be changed. Please try again."
+		} else {
+			response.PendingEmail = *updateProfileUser.Email
+		}
+	}
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (u *Users) cancelEmailChange(r *http.Request, userID uint) {
+	err := u.us.WithContext(r.Context()).CancelEmailChange(userID)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("could not cancel the email change", "error", err)
+	}
+}
+
+// POST /api/me/email/confirm
+// Swap the user's email for the new address the token was sent
+// to. Every session is signed out and a new token is issued.
+func (u *Users) ConfirmEmail(w http.ResponseWriter, r *http.Request) {
+	var confirmEmailUser ConfirmEmailUser
+
+	err := decodeJSON(r, &confirmEmailUser)
+	if err != nil {
+		sendDecodeError(w, err, http.StatusBadRequest, "Cannot get the token.")
+		return
+	}
+
+	user, oldEmail, err := u.us.WithContext(r.Context()).CompleteEmailChange(confirmEmailUser.Token)
+	if err != nil {
+		switch err {
+		case models.ErrTokenInvalid:
+			sendErrorResponse(w, http.StatusForbidden, publicMessage(err))
+		default:
+			sendValidationError(w, err, "Cannot change the email.")
+		}
+		return
+	}
+
+	// The change is done, failing to notify should not undo it
+	err = u.emailer.EmailChanged(r.Context(), oldEmail, user.Email)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("could not notify the old address of the email change", "user_id", user.ID, "error", err)
+	}
+
+	signingKey := r.Context().Value("signingKey").(string)
+	err = u.signIn(w, r, user, signingKey)
+	if err != nil {
+		sendErrorResponse(w, http.StatusFound, "Changed the email. Cannot signin.")
+		return
+	}
+}
+
+// POST /api/me/password
+// Change the password of the logged in user. Every other
+// session is signed out and a new token is issued.
+func (u *Users) ChangePassword(w http.ResponseWriter, r *http.Request) {
+	loggedInUser := middlewares.LookUpUserFromContext(r.Context())
+	if loggedInUser == nil {
+		sendErrorResponse(w, http.StatusForbidden, "User not found.")
+		return
+	}
+
+	var changePasswordUser ChangePasswordUser
+	err := decodeJSON(r, &changePasswordUser)
+	if err != nil {
+		sendDecodeError(w, err, http.StatusBadRequest, "Cannot get the passwords.")
+		return
+	}
+
+	user, err := u.us.WithContext(r.Context()).GetById(loggedInUser.ID)
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "User not found.")
+		return
+	}
+
+	err = u.us.WithContext(r.Context()).ChangePassword(user, changePasswordUser.CurrentPassword, changePasswordUser.NewPassword)
+	if err != nil {
+		switch err {
+		case models.ErrInvalidEmailOrPassword:
+			sendErrorResponse(w, http.StatusForbidden, "Current password is incorrect.")
+		default:
+			sendValidationError(w, err, "Cannot change the password.")
+		}
+		return
+	}
+
+	err = u.emailer.PasswordChanged(r.Context(), user.Email)
+	if err != nil {
+		logging.FromContext(r.Context()).Error("could not notify of the password change", "user_id", user.ID, "error", err)
+	}
+
+	signingKey := r.Context().Value("signingKey").(string)
+	err = u.signIn(w, r, user, signingKey)
+	if err != nil {
+		sendErrorResponse(w, http.StatusFound, "Changed the password. Cannot signin.")
+		return
+	}
+}
+
 // POST /api/forgot_password
-// Send user an email with a token to reset the password
+// Send user an email with a token to reset the password.
+// The response is the same whether or not the email belongs
+// to a user, so it cannot be used to find out who signed up.
+// The token is created and emailed in the background, so that
+// the response time does not tell either.
 func (u *Users) InitiateReset(w http.ResponseWriter, r *http.Request) {
 	var resetPasswordUser ResetPasswordUser
 
 	// Get user info
-	err := json.NewDecoder(r.Body).Decode(&resetPasswordUser)
+	err := decodeJSON(r, &resetPasswordUser)
 	if err != nil {
-		sendErrorResponse(w, http.StatusForbidden, "Cannot reset. Could not get user info.")
+		sendDecodeError(w, err, http.StatusBadRequest, "Cannot reset. Could not get user info.")
 		return
 	}
 
-	// Create a token to start resetting user password
-	token, err := u.us.InitiateReset(resetPasswordUser.Email)
+	toEmail := resetPasswordUser.Email
+	logger := logging.FromContext(r.Context())
+	err = u.queue.Enqueue(func(ctx context.Context) {
+		// Create a token to start resetting user password
+		token, err := u.us.WithContext(ctx).InitiateReset(toEmail)
+		switch err {
+		case nil:
+			// Email the user the password reset token
+			err = u.emailer.ResetPassword(ctx, toEmail, token)
+			if err != nil {
+				logger.Error("could not email the password reset link", "error", err)
+			}
+		case models.ErrNotFound:
+		default:
+			logger.Error("could not issue a password reset link", "error", err)
+		}
+	})
 	if err != nil {
-		sendErrorResponse(w, http.StatusForbidden, "Cannot reset. Could not issue a reset token.")
+		sendErrorResponse(w, http.StatusServiceUnavailable, "Cannot reset the password right now, please try again later.")
 		return
 	}
 
-	// Email the user the password reset token
-	err = u.emailer.ResetPassword(resetPasswordUser.Email, token)
-	if err != nil {
-		sendErrorResponse(w, http.StatusForbidden, "Cannot reset. Could not email to the user.")
-		return
-	}
+	setSuccessStatus(w, http.StatusAccepted)
+	json.NewEncoder(w).Encode(ErrorMessage{Message: "If an account uses this email, we sent it instructions to reset the password."})
 }
 
 // POST /api/update_password
+// The token is read from the body, or from the url the
+// reset email links to
 func (u *Users) CompleteReset(w http.ResponseWriter, r *http.Request) {
 	var resetPasswordUser ResetPasswordUser
 
 	// Get user info
-	err := json.NewDecoder(r.Body).Decode(&resetPasswordUser)
+	err := decodeJSON(r, &resetPasswordUser)
 	if err != nil {
-		sendErrorResponse(w, http.StatusForbidden, "Cannot get user info.")
+		sendDecodeError(w, err, http.StatusBadRequest, "Cannot get user info.")
 		return
 	}
 
+	token := resetPasswordUser.Token
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+
 	// Reset user password (Update with new password)
-	token := r.URL.Query().Get("token") // Get token from URL param
-	user, err := u.us.CompleteReset(token, resetPasswordUser.Password)
+	user, err := u.us.WithContext(r.Context()).CompleteReset(token, resetPasswordUser.Password)
 	if err != nil {
-		sendErrorResponse(w, http.StatusForbidden, "Cannot update with new password.")
+		switch err {
+		case models.ErrTokenInvalid:
+			sendErrorResponse(w, http.StatusForbidden, publicMessage(err))
+		default:
+			sendValidationError(w, err, "Cannot update with new password.")
+		}
 		return
 	}
 
 	// Let user sign-in
 	signingKey := r.Context().Value("signingKey").(string)
-	u.signIn(w, user, signingKey)
+	err = u.signIn(w, r, user, signingKey)
 	if err != nil {
 		sendErrorResponse(w, http.StatusFound, "Updated user password. Cannot signin.")
 		return
 	}
 }
+
+// POST /api/admin/users/:id/restore
+// Restore a soft-deleted user (admin only)
+func (u *Users) Restore(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(mux.Vars(r)["id"])
+	if err != nil {
+		sendErrorResponse(w, http.StatusNotFound, "Invalid User ID.")
+		return
+	}
+
+	err = u.us.WithContext(r.Context()).Restore(uint(id))
+	if err != nil {
+		switch err {
+		case models.ErrNotFound:
+			sendErrorResponse(w, http.StatusNotFound, "User not found in trash.")
+		default:
+			sendErrorResponse(w, http.StatusInternalServerError, "Could not restore the user.")
+		}
+		return
+	}
+
+	user, err := u.us.WithContext(r.Context()).GetById(uint(id))
+	if err != nil {
+		sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		return
+	}
+
+	setSuccessStatus(w, http.StatusOK)
+	json.NewEncoder(w).Encode(middlewares.UserWithToken{
+		ID:        user.ID,
+		Username:  user.Username,
+		UserEmail: user.Email,
+	})
+}
@@ -1,16 +1,31 @@
 package email
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"html"
 	"net/url"
+	"strings"
+
+	"go_rest_pg_starter/metrics"
+	"go_rest_pg_starter/tracing"
 
 	mailgun "gopkg.in/mailgun/mailgun-go.v1"
 )
 
 const (
-	resetBaseURL   = "http://localhost:3000/api/update_password" // Change this for production
-	welcomeSubject = "Welcome!"
-	resetSubject   = "Instructions for resetting your password."
+	defaultBaseURL         = "http://localhost:3000"
+	resetPath              = "/api/update_password"
+	magicLinkPath          = "/api/login/magic/verify"
+	verifyEmailPath        = "/api/me/email/confirm"
+	welcomeSubject         = "Welcome!"
+	resetSubject           = "Instructions for resetting your password."
+	verifyEmailSubject     = "Please confirm your new email address."
+	exportSubject          = "Your data export is ready."
+	magicLinkSubject       = "Your sign in link."
+	emailChangedSubject    = "The email address of your account was changed."
+	passwordChangedSubject = "The password of your account was changed."
 )
 
 const welcomeText = `
@@ -65,6 +80,140 @@ const resetHTMLTmpl = `
 	Support<br/>
 `
 
+const verifyEmailTextTmpl = `
+	Hi there!
+
+	You asked to change the email address of your account to this one. Please follow the link below to confirm it:
+
+	%s
+
+	If you are asked for a token, please use the following value:
+
+	%s
+
+	If you didn't ask for this change you can safely ignore this email.
+
+	Best,
+	Support
+`
+
+const verifyEmailHTMLTmpl = `
+	Hi there!<br/>
+	<br/>
+	You asked to change the email address of your account to this one. Please follow the link below to confirm it:<br/>
+	<br/>
+	<a href="%s">%s</a><br/>
+	<br/>
+	If you are asked for a token, please use the following value:<br/>
+	<br/>
+	%s<br/>
+	<br/>
+	If you didn't ask for this change you can safely ignore this email.<br/>
+	<br/>
+	Best,<br/>
+	Support<br/>
+`
+
+const magicLinkTextTmpl = `
+	Hi there!
+
+	Please follow the link below to sign in. It can only be used once and expires shortly:
+
+	%s
+
+	If you are asked for a token, please use the following value:
+
+	%s
+
+	If you didn't try to sign in you can safely ignore this email.
+
+	Best,
+	Support
+`
+
+const magicLinkHTMLTmpl = `
+	Hi there!<br/>
+	<br/>
+	Please follow the link below to sign in. It can only be used once and expires shortly:<br/>
+	<br/>
+	<a href="%s">%s</a><br/>
+	<br/>
+	If you are asked for a token, please use the following value:<br/>
+	<br/>
+	%s<br/>
+	<br/>
+	If you didn't try to sign in you can safely ignore this email.<br/>
+	<br/>
+	Best,<br/>
+	Support<br/>
+`
+
+const exportTextTmpl = `
+	Hi there!
+
+	The copy of your data you asked for is ready. You can download it from the link below for the next %d days:
+
+	%s
+
+	Best,
+	Support
+`
+
+const exportHTMLTmpl = `
+	Hi there!<br/>
+	<br/>
+	The copy of your data you asked for is ready. You can download it from the link below for the next %d days:<br/>
+	<br/>
+	<a href="%s">%s</a><br/>
+	<br/>
+	Best,<br/>
+	Support<br/>
+`
+
+const emailChangedTextTmpl = `
+	Hi there!
+
+	The email address of your account was just changed to %s, and every device was signed out. You will not get emails at this address anymore.
+
+	If you didn't make this change, please contact support right away.
+
+	Best,
+	Support
+`
+
+const emailChangedHTMLTmpl = `
+	Hi there!<br/>
+	<br/>
+	The email address of your account was just changed to %s, and every device was signed out. You will not get emails at this address anymore.<br/>
+	<br/>
+	If you didn't make this change, please contact support right away.<br/>
+	<br/>
+	Best,<br/>
+	Support<br/>
+`
+
+const passwordChangedText = `
+	Hi there!
+
+	The password of your account was just changed, and every other device was signed out.
+
+	If you didn't make this change, please reset your password right away.
+
+	Best,
+	Support
+`
+
+const passwordChangedHTML = `
+	Hi there!<br/>
+	<br/>
+	The password of your account was just changed, and every other device was signed out.<br/>
+	<br/>
+	If you didn't make this change, please reset your password right away.<br/>
+	<br/>
+	Best,<br/>
+	Support<br/>
+`
+
 func WithMailgun(domain, apiKey, publicKey string) ClientConfig {
 	return func(client *Client) {
 		mg := mailgun.NewMailgun(domain, apiKey, publicKey)
@@ -78,12 +227,29 @@ func WithSender(username, email string) ClientConfig {
 	}
 }
 
+// WithTracer records a span for each email sent
+func WithTracer(tracer *tracing.Tracer) ClientConfig {
+	return func(client *Client) {
+		client.tracer = tracer
+	}
+}
+
+// WithBaseURL sets where users reach the app. Links in emails
+// start with it.
+func WithBaseURL(baseURL string) ClientConfig {
+	return func(client *Client) {
+		client.baseURL = strings.TrimSuffix(baseURL, "/")
+	}
+}
+
 type ClientConfig func(*Client)
 
 func NewClient(opts ...ClientConfig) *Client {
 	client := Client{
 		// Set a default from email address...
-		from: "support@example.com",
+		from:    "support@example.com",
+		baseURL: defaultBaseURL,
+		tracer:  tracing.NewTracer(nil, 0),
 	}
 	for _, opt := range opts {
 		opt(&client)
@@ -92,14 +258,46 @@ func NewClient(opts ...ClientConfig) *Client {
 }
 
 type Client struct {
-	from string
-	mg   mailgun.Mailgun
+	from    string
+	baseURL string
+	mg      mailgun.Mailgun
+	tracer  *tracing.Tracer
+}
+
+var emailsSentTotal = metrics.NewCounterVec("emails_sent_total",
+	"Emails sent through Mailgun, by kind and result.", "kind", "result")
+
+var ErrNotConfigured = errors.New("email: Mailgun domain and API key are not set")
+
+// CheckConfigured reports whether emails can be sent at all.
+// It does not contact Mailgun.
+func (client *Client) CheckConfigured() error {
+	if client.mg == nil || client.mg.Domain() == "" || client.mg.ApiKey() == "" {
+		return ErrNotConfigured
+	}
+	return nil
 }
 
-func (client *Client) Welcome(toUsername, toEmail string) error {
+// Sends the message, counting the outcome by kind of email
+func (client *Client) send(ctx context.Context, kind string, message *mailgun.Message) error {
+	_, span := client.tracer.Start(ctx, "email.send "+kind, tracing.SpanKindClient)
+	defer span.End()
+	span.SetAttribute("email.kind", kind)
+
+	_, _, err := client.mg.Send(message)
+	if err != nil {
+		span.SetError(err)
+		emailsSentTotal.Inc(kind, "failure")
+		return err
+	}
+	emailsSentTotal.Inc(kind, "success")
+	return nil
+}
+
+func (client *Client) Welcome(ctx context.Context, toUsername, toEmail string) error {
 	message := mailgun.NewMessage(client.from, welcomeSubject, welcomeText, buildEmail(toUsername, toEmail))
 	message.SetHtml(welcomeHTML)
-	_, _, err := client.mg.Send(message)
+	err := client.send(ctx, "welcome", message)
 	if err != nil {
 		return err
 	}
@@ -113,14 +311,68 @@ func buildEmail(username, email string) string {
 	return fmt.Sprintf("%s <%s>", username, email)
 }
 
-func (client *Client) ResetPassword(toEmail, token string) error {
+func (client *Client) ResetPassword(ctx context.Context, toEmail, token string) error {
 	v := url.Values{}
 	v.Set("token", token)
-	resetUrl := resetBaseURL + "?" + v.Encode()
+	resetUrl := client.baseURL + resetPath + "?" + v.Encode()
 	resetText := fmt.Sprintf(resetTextTmpl, resetUrl, token)
 	message := mailgun.NewMessage(client.from, resetSubject, resetText, toEmail)
 	resetHTML := fmt.Sprintf(resetHTMLTmpl, resetUrl, resetUrl, token)
 	message.SetHtml(resetHTML)
-	_, _, err := client.mg.Send(message)
+	err := client.send(ctx, "reset_password", message)
+	return err
+}
+
+func (client *Client) VerifyEmailChange(ctx context.Context, toEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	verifyUrl := client.baseURL + verifyEmailPath + "?" + v.Encode()
+	verifyText := fmt.Sprintf(verifyEmailTextTmpl, verifyUrl, token)
+	message := mailgun.NewMessage(client.from, verifyEmailSubject, verifyText, toEmail)
+	verifyHTML := fmt.Sprintf(verifyEmailHTMLTmpl, verifyUrl, verifyUrl, token)
+	message.SetHtml(verifyHTML)
+	err := client.send(ctx, "verify_email_change", message)
+	return err
+}
+
+func (client *Client) MagicLink(ctx context.Context, toEmail, token string) error {
+	v := url.Values{}
+	v.Set("token", token)
+	magicUrl := client.baseURL + magicLinkPath + "?" + v.Encode()
+	magicText := fmt.Sprintf(magicLinkTextTmpl, magicUrl, token)
+	message := mailgun.NewMessage(client.from, magicLinkSubject, magicText, toEmail)
+	magicHTML := fmt.Sprintf(magicLinkHTMLTmpl, magicUrl, magicUrl, token)
+	message.SetHtml(magicHTML)
+	err := client.send(ctx, "magic_link", message)
+	return err
+}
+
+// EmailChanged lets the previous address of an account know
+// that it was replaced by newEmail.
+func (client *Client) EmailChanged(ctx context.Context, oldEmail, newEmail string) error {
+	changedText := fmt.Sprintf(emailChangedTextTmpl, newEmail)
+	message := mailgun.NewMessage(client.from, emailChangedSubject, changedText, oldEmail)
+	changedHTML := fmt.Sprintf(emailChangedHTMLTmpl, html.EscapeString(newEmail))
+	message.SetHtml(changedHTML)
+	err := client.send(ctx, "email_changed", message)
+	return err
+}
+
+func (client *Client) PasswordChanged(ctx context.Context, toEmail string) error {
+	message := mailgun.NewMessage(client.from, passwordChangedSubject, passwordChangedText, toEmail)
+	message.SetHtml(passwordChangedHTML)
+	err := client.send(ctx, "password_changed", message)
+	return err
+}
+
+// DataExportReady sends the link to download a data export.
+// path is the signed path of the download endpoint.
+func (client *Client) DataExportReady(ctx context.Context, toEmail, path string, validDays int) error {
+	downloadUrl := client.baseURL + path
+	exportText := fmt.Sprintf(exportTextTmpl, validDays, downloadUrl)
+	message := mailgun.NewMessage(client.from, exportSubject, exportText, toEmail)
+	exportHTML := fmt.Sprintf(exportHTMLTmpl, validDays, downloadUrl, downloadUrl)
+	message.SetHtml(exportHTML)
+	err := client.send(ctx, "data_export_ready", message)
 	return err
 }
@@ -1,5 +1,7 @@
 module go_rest_pg_starter
 
+go 1.21
+
 require (
 	github.com/dgrijalva/jwt-go v0.0.0-20180719211823-0b96aaa70776
 	github.com/gorilla/mux v1.7.0
@@ -10,3 +12,5 @@ require (
 	golang.org/x/crypto v0.0.0-20190228161510-8dd112bcdc25
 	gopkg.in/mailgun/mailgun-go.v1 v1.1.0
 )
+
+require golang.org/x/sys v0.0.0-20190215142949-d0b11bdaac8a // indirect
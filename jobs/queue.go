@@ -0,0 +1,88 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"sync"
+)
+
+var (
+	ErrQueueFull    = errors.New("jobs: queue is full")
+	ErrQueueStopped = errors.New("jobs: queue is stopped")
+)
+
+// Queue runs tasks in the background on a fixed number of workers
+type Queue struct {
+	tasks   chan func(ctx context.Context)
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	stopped bool
+	wg      sync.WaitGroup
+}
+
+// NewQueue starts the workers. At most size tasks can
+// wait for a worker at any time.
+func NewQueue(workers, size int) *Queue {
+	ctx, cancel := context.WithCancel(context.Background())
+	q := &Queue{
+		tasks:  make(chan func(ctx context.Context), size),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+	for i := 0; i < workers; i++ {
+		q.wg.Add(1)
+		go q.work()
+	}
+	return q
+}
+
+// Enqueue schedules the task without waiting for it to run
+func (q *Queue) Enqueue(task func(ctx context.Context)) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.stopped {
+		return ErrQueueStopped
+	}
+	select {
+	case q.tasks <- task:
+		return nil
+	default:
+		return ErrQueueFull
+	}
+}
+
+// Stop stops accepting tasks and waits for the queued ones to
+// finish. If ctx is done first, the context given to running
+// tasks is canceled and Stop returns ctx.Err().
+func (q *Queue) Stop(ctx context.Context) error {
+	q.mu.Lock()
+	if !q.stopped {
+		q.stopped = true
+		close(q.tasks)
+	}
+	q.mu.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		q.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		q.cancel()
+		return nil
+	case <-ctx.Done():
+		q.cancel()
+		<-done
+		return ctx.Err()
+	}
+}
+
+func (q *Queue) work() {
+	defer q.wg.Done()
+	for task := range q.tasks {
+		task(q.ctx)
+	}
+}
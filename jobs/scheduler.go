@@ -0,0 +1,71 @@
+package jobs
+
+import (
+	"log/slog"
+	"sync"
+	"time"
+)
+
+type job struct {
+	name     string
+	interval time.Duration
+	run      func(now time.Time) error
+}
+
+// Scheduler runs registered jobs periodically in the
+// background until it is stopped.
+type Scheduler struct {
+	jobs []job
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func NewScheduler() *Scheduler {
+	return &Scheduler{
+		stop: make(chan struct{}),
+	}
+}
+
+// Every registers a job to be run once per interval.
+// Jobs must be registered before the scheduler is started.
+func (s *Scheduler) Every(interval time.Duration, name string, run func(now time.Time) error) {
+	s.jobs = append(s.jobs, job{
+		name:     name,
+		interval: interval,
+		run:      run,
+	})
+}
+
+// Start runs each job in its own goroutine
+func (s *Scheduler) Start() {
+	for _, j := range s.jobs {
+		s.wg.Add(1)
+		go s.loop(j)
+	}
+}
+
+// Stop signals every job to stop and waits for
+// the ones currently running to finish.
+func (s *Scheduler) Stop() {
+	close(s.stop)
+	s.wg.Wait()
+}
+
+func (s *Scheduler) loop(j job) {
+	defer s.wg.Done()
+
+	ticker := time.NewTicker(j.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-s.stop:
+			return
+		case now := <-ticker.C:
+			err := j.run(now)
+			if err != nil {
+				slog.Error("job failed", "job", j.name, "error", err)
+			}
+		}
+	}
+}
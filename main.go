@@ -1,14 +1,28 @@
 package main
 
 import (
+	"context"
+	"flag"
 	"fmt"
+	"log/slog"
 	"os"
+	"os/signal"
+	"syscall"
+	"time"
 
 	"go_rest_pg_starter/config"
 	"go_rest_pg_starter/controllers"
 	"go_rest_pg_starter/email"
+	"go_rest_pg_starter/jobs"
+	"go_rest_pg_starter/logging"
+	"go_rest_pg_starter/metrics"
 	"go_rest_pg_starter/middlewares"
 	"go_rest_pg_starter/models"
+	"go_rest_pg_starter/oidc"
+	"go_rest_pg_starter/pwned"
+	"go_rest_pg_starter/storage"
+	"go_rest_pg_starter/tracing"
+	"go_rest_pg_starter/utils"
 
 	"net/http"
 
@@ -20,22 +34,143 @@ func main() {
 		Config, Setup services
 	*/
 
-	config := config.GetConfig()
+	config, err := config.Load(os.Args[1:])
+	if err == flag.ErrHelp {
+		os.Exit(0)
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
 	dbConfig := config.Database
+
+	// Also used by the standard log package from here on
+	logger := logging.New(os.Stdout, logging.ParseLevel(config.LogLevel))
+	slog.SetDefault(logger)
+
+	tracingConfig := config.Tracing
+	var spanExporter tracing.Exporter
+	switch tracingConfig.Exporter {
+	case "otlp":
+		spanExporter = tracing.NewOTLPExporter(tracingConfig.OTLPEndpoint,
+			tracingConfig.ServiceName, tracingConfig.OTLPHeaders)
+	case "stdout":
+		spanExporter = tracing.NewWriterExporter(os.Stdout, tracingConfig.ServiceName)
+	}
+	tracer := tracing.NewTracer(spanExporter, tracingConfig.SampleRatio)
+
+	passwordConfig := config.Password
+	passwordPolicy := models.DefaultPasswordPolicy()
+	passwordPolicy.MinLength = passwordConfig.MinLength
+	passwordPolicy.MaxLength = passwordConfig.MaxLength
+	passwordPolicy.MinCharClasses = passwordConfig.MinCharClasses
+	if passwordConfig.CheckBreached {
+		passwordPolicy.BreachedChecker = pwned.NewChecker(pwned.NewHTTPSource(passwordConfig.PwnedURL))
+	}
+
 	services, err := models.NewServices(
-		models.WithGorm(dbConfig.Dialect(), dbConfig.ConnectionInfo()),
+		models.WithGorm(dbConfig.Dialect(), dbConfig.ConnectionInfo(), models.PoolConfig{
+			MaxOpenConns:    dbConfig.MaxOpenConns,
+			MaxIdleConns:    dbConfig.MaxIdleConns,
+			ConnMaxLifetime: dbConfig.ConnMaxLifetime(),
+		}),
+		models.WithLogger(logger),
+		models.WithTracer(tracer),
 		models.WithLogMode(!config.IsProd()),
-		models.WithUser(config.Pepper, config.HMACKey),
+		models.WithUser(config.Pepper, config.HMACKey,
+			models.WithPasswordResetTTL(config.PasswordResetTTL()),
+			models.WithMagicLinkTTL(config.MagicLinkTTL()),
+			models.WithPasswordPolicy(passwordPolicy),
+			models.WithPasswordHashing(models.PasswordHashConfig{
+				Algorithm:     passwordConfig.HashAlgorithm,
+				BcryptCost:    passwordConfig.BcryptCost,
+				Argon2Time:    uint32(passwordConfig.Argon2Time),
+				Argon2Memory:  uint32(passwordConfig.Argon2MemoryKiB),
+				Argon2Threads: uint8(passwordConfig.Argon2Threads),
+				PepperID:      config.PepperID,
+				OldPeppers:    config.OldPeppers,
+			})),
 		models.WithPost(),
+		models.WithFollow(),
+		models.WithAttachment(),
+		models.WithAccount(),
+		models.WithIdentity(),
+		models.WithSession(config.HMACKey),
 	)
 	if err != nil {
 		panic(err)
 	}
 
-	defer services.Close()
-	services.AutoMigrate()
+	// Serving with a schema the code does not expect would fail
+	// requests in ways harder to notice
+	err = services.AutoMigrate()
+	if err != nil {
+		panic(err)
+	}
+	metrics.RegisterDBStats(services.DBStats)
 	// services.DestructiveReset() // Comment this out for not resetting DB everytime it restarts
 
+	/*
+		File storage
+	*/
+	var blobs storage.BlobStore
+	storageConfig := config.Storage
+	switch storageConfig.Driver {
+	case "s3":
+		blobs, err = storage.NewS3Store(storage.S3Config{
+			Endpoint:        storageConfig.S3Endpoint,
+			Region:          storageConfig.S3Region,
+			Bucket:          storageConfig.S3Bucket,
+			AccessKeyID:     storageConfig.S3AccessKeyID,
+			SecretAccessKey: storageConfig.S3SecretAccessKey,
+		})
+	default:
+		blobs, err = storage.NewLocalStore(storageConfig.LocalDir)
+	}
+	if err != nil {
+		panic(err)
+	}
+
+	/*
+		Background jobs
+	*/
+	queue := jobs.NewQueue(2, 100)
+
+	scheduler := jobs.NewScheduler()
+	scheduler.Every(time.Minute, "publish scheduled posts", func(now time.Time) error {
+		_, err := services.Post.PublishDue(now)
+		return err
+	})
+	scheduler.Every(time.Hour, "purge deleted records", func(now time.Time) error {
+		blobKeys, err := services.PurgeDeleted(now.Add(-config.TrashRetention()))
+		if err != nil {
+			return err
+		}
+		for _, key := range blobKeys {
+			blobs.Delete(context.Background(), key)
+		}
+		return nil
+	})
+	scheduler.Every(time.Hour, "delete expired password resets and magic links", func(now time.Time) error {
+		_, err := services.User.DeleteExpiredTokens(now)
+		return err
+	})
+	scheduler.Every(time.Hour, "delete ended sessions", func(now time.Time) error {
+		_, err := services.Session.DeleteEnded(now)
+		return err
+	})
+	scheduler.Every(time.Hour, "delete expired data exports", func(now time.Time) error {
+		blobKeys, err := services.Account.DeleteExportsBefore(now.Add(-config.ExportValidity()))
+		if err != nil {
+			return err
+		}
+		for _, key := range blobKeys {
+			blobs.Delete(context.Background(), key)
+		}
+		return nil
+	})
+	scheduler.Start()
+
 	/*
 		Mailgun setup
 	*/
@@ -43,38 +178,209 @@ func main() {
 	emailer := email.NewClient(
 		email.WithSender("Support", "support@"+mailgunConfig.Domain),
 		email.WithMailgun(mailgunConfig.Domain, mailgunConfig.APIKey, mailgunConfig.PublicAPIKey),
+		email.WithTracer(tracer),
+		email.WithBaseURL(config.PublicBaseURL),
 	)
 
-	r := mux.NewRouter()
-	r = r.PathPrefix("/api").Subrouter()
+	/*
+		Identity providers setup
+	*/
+	providers := make(map[string]*oidc.Client)
+	for _, providerConfig := range config.OIDCProviders {
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		provider, err := oidc.NewClient(ctx, oidc.Config{
+			Issuer:       providerConfig.Issuer,
+			ClientID:     providerConfig.ClientID,
+			ClientSecret: providerConfig.ClientSecret,
+			RedirectURL:  config.OIDCRedirectBaseURL + "/api/auth/" + providerConfig.Name + "/callback",
+			Scopes:       providerConfig.Scopes,
+		})
+		cancel()
+		// One provider being down should not keep the others from working
+		if err != nil {
+			logger.Error("could not set up identity provider", "provider", providerConfig.Name, "error", err)
+			continue
+		}
+		providers[providerConfig.Name] = provider
+	}
+
+	router := mux.NewRouter()
+	r := router.PathPrefix("/api").Subrouter()
 
 	/*
 		Defines controllers
 	*/
-	usersCtrl := controllers.NewUsers(services.User, emailer)
-	postsCtrl := controllers.NewPosts(services.Post)
+	usersCtrl := controllers.NewUsers(services.User, services.Session, emailer, queue, config.CookieSecure)
+	postsCtrl := controllers.NewPosts(services.Post, services.User)
+	followsCtrl := controllers.NewFollows(services.Follow, services.User)
+	signer := storage.NewURLSigner(utils.DeriveKey(config.HMACKey, "signed-urls"))
+	accountsCtrl := controllers.NewAccounts(services.Account, services.User, emailer, blobs, signer, queue, config.ExportValidDays)
+	attachmentsCtrl := controllers.NewAttachments(services.Attachment, services.Post, blobs,
+		signer, storageConfig.MaxUploadBytes())
+
+	sessionsCtrl := controllers.NewSessions(services.Session)
+	healthCtrl := controllers.NewHealth(services, emailer)
+	identitiesCtrl := controllers.NewIdentities(services.Identity, usersCtrl, providers,
+		utils.DeriveKey(config.HMACKey, "oidc-state"))
 
 	userMW := middlewares.User{
 		UserService: services.User,
+		Sessions:    services.Session,
+		SigningKey:  config.SigningKey,
 	}
+	// For routes reading a JSON body
+	jsonBody := middlewares.JSONBody(config.Server.MaxBodyBytes())
+	postBody := middlewares.JSONBody(config.Server.MaxPostBodyBytes())
+	// For routes responding with tokens or account details
+	noStore := middlewares.NoStore
+
+	/*
+		Health and metrics routes, outside of /api
+	*/
+	router.HandleFunc("/healthz", healthCtrl.Live).Methods("GET")
+	router.HandleFunc("/readyz", healthCtrl.Ready).Methods("GET")
+	router.Handle("/metrics", metrics.Handler(config.MetricsToken)).Methods("GET")
 
 	/*
 		Users routes
 	*/
-	r.HandleFunc("/signup", usersCtrl.Create).Methods("POST")
-	r.HandleFunc("/login", usersCtrl.Login).Methods("POST")
-	r.HandleFunc("/forgot_password", usersCtrl.InitiateReset).Methods("POST")
-	r.HandleFunc("/update_password", usersCtrl.CompleteReset).Methods("POST")
-	r.HandleFunc("/me", userMW.RequireUser(usersCtrl.Me)).Methods("GET")
+	r.HandleFunc("/signup", noStore(jsonBody(usersCtrl.Create))).Methods("POST")
+	r.HandleFunc("/login", noStore(jsonBody(usersCtrl.Login))).Methods("POST")
+	r.HandleFunc("/logout", noStore(userMW.RequireUser(usersCtrl.Logout))).Methods("POST")
+	r.HandleFunc("/login/magic", jsonBody(usersCtrl.InitiateMagicLogin)).Methods("POST")
+	r.HandleFunc("/login/magic/verify", noStore(jsonBody(usersCtrl.CompleteMagicLogin))).Methods("POST")
+	r.HandleFunc("/forgot_password", jsonBody(usersCtrl.InitiateReset)).Methods("POST")
+	r.HandleFunc("/update_password", noStore(jsonBody(usersCtrl.CompleteReset))).Methods("POST")
+	r.HandleFunc("/me", noStore(userMW.RequireUser(usersCtrl.Me))).Methods("GET")
+	r.HandleFunc("/me", noStore(userMW.RequireUser(jsonBody(usersCtrl.UpdateMe)))).Methods("PATCH")
+	r.HandleFunc("/me", userMW.RequireUser(jsonBody(accountsCtrl.Delete))).Methods("DELETE")
+	r.HandleFunc("/me/export", userMW.RequireUser(accountsCtrl.Export)).Methods("POST")
+	r.HandleFunc("/exports/{id:[0-9]+}/download", accountsCtrl.Download).Methods("GET")
+	r.HandleFunc("/me/email/confirm", noStore(jsonBody(usersCtrl.ConfirmEmail))).Methods("POST")
+	r.HandleFunc("/me/password", noStore(userMW.RequireUser(jsonBody(usersCtrl.ChangePassword)))).Methods("POST")
+	r.HandleFunc("/me/sessions", noStore(userMW.RequireUser(sessionsCtrl.List))).Methods("GET")
+	r.HandleFunc("/me/sessions/{id:[0-9]+}", userMW.RequireUser(sessionsCtrl.Revoke)).Methods("DELETE")
+	r.HandleFunc("/me/trash", userMW.RequireUser(postsCtrl.Trash)).Methods("GET")
+	r.HandleFunc("/users/{username}", usersCtrl.Show).Methods("GET")
+	r.HandleFunc("/users/{username}/posts", userMW.OptionalUser(postsCtrl.ByUser)).Methods("GET")
+
+	/*
+		Identity provider routes
+	*/
+	r.HandleFunc("/auth/providers", identitiesCtrl.Providers).Methods("GET")
+	r.HandleFunc("/auth/{provider}/login", identitiesCtrl.Login).Methods("GET")
+	r.HandleFunc("/auth/{provider}/callback", noStore(identitiesCtrl.Callback)).Methods("GET")
+	r.HandleFunc("/me/identities", noStore(userMW.RequireUser(identitiesCtrl.List))).Methods("GET")
+	r.HandleFunc("/me/identities/{provider}", noStore(userMW.RequireUser(identitiesCtrl.Link))).Methods("POST")
+	r.HandleFunc("/me/identities/{provider}", userMW.RequireUser(identitiesCtrl.Unlink)).Methods("DELETE")
+
+	/*
+		Admin routes
+	*/
+	r.HandleFunc("/admin/users/{id:[0-9]+}/restore", userMW.RequireAdmin(usersCtrl.Restore)).Methods("POST")
+
+	/*
+		Follow routes
+	*/
+	r.HandleFunc("/users/{id:[0-9]+}/follow", userMW.RequireUser(followsCtrl.Follow)).Methods("POST")
+	r.HandleFunc("/users/{id:[0-9]+}/follow", userMW.RequireUser(followsCtrl.Unfollow)).Methods("DELETE")
+	r.HandleFunc("/users/{id:[0-9]+}/followers", followsCtrl.Followers).Methods("GET")
+	r.HandleFunc("/users/{id:[0-9]+}/following", followsCtrl.Following).Methods("GET")
+	r.HandleFunc("/feed", userMW.RequireUser(postsCtrl.Feed)).Methods("GET")
 
 	/*
 		Posts routes
 	*/
-	r.HandleFunc("/posts", userMW.RequireUser(postsCtrl.Create)).Methods("POST")
-	r.HandleFunc("/posts/{id:[0-9]+}", postsCtrl.GetOne).Methods("GET")
-	r.HandleFunc("/posts/{id:[0-9]+}/update", userMW.RequireUser(postsCtrl.Update)).Methods("PUT")
+	r.HandleFunc("/posts", userMW.RequireUser(postBody(postsCtrl.Create))).Methods("POST")
+	r.HandleFunc("/posts/{id:[0-9]+}", userMW.OptionalUser(postsCtrl.GetOne)).Methods("GET")
+	r.HandleFunc("/posts/{id:[0-9]+}/update", userMW.RequireUser(postBody(postsCtrl.Update))).Methods("PUT")
 	r.HandleFunc("/posts/{id:[0-9]+}/delete", userMW.RequireUser(postsCtrl.Delete)).Methods("DELETE")
+	r.HandleFunc("/posts/{id:[0-9]+}/restore", userMW.RequireUser(postsCtrl.Restore)).Methods("POST")
+	r.HandleFunc("/posts/{id:[0-9]+}/reactions/{kind}", userMW.RequireUser(postsCtrl.React)).Methods("PUT")
+	r.HandleFunc("/posts/{id:[0-9]+}/reactions/{kind}", userMW.RequireUser(postsCtrl.Unreact)).Methods("DELETE")
+
+	/*
+		Attachments routes
+	*/
+	r.HandleFunc("/posts/{id:[0-9]+}/attachments", userMW.RequireUser(attachmentsCtrl.Create)).Methods("POST")
+	r.HandleFunc("/posts/{id:[0-9]+}/attachments", userMW.OptionalUser(attachmentsCtrl.List)).Methods("GET")
+	r.HandleFunc("/posts/{id:[0-9]+}/attachments/{attachment_id:[0-9]+}", userMW.RequireUser(attachmentsCtrl.Delete)).Methods("DELETE")
+	r.HandleFunc("/attachments/{id:[0-9]+}/download", attachmentsCtrl.Download).Methods("GET")
+
+	/*
+		Serve until stopped
+	*/
+	var handler http.Handler = router
+	handler = middlewares.Recover(router, handler)
+	handler = middlewares.CORS(config.CORS, router, handler)
+	handler = middlewares.SecurityHeaders(config.IsProd(), handler)
+	handler = middlewares.AccessLog(router, handler)
+	handler = middlewares.Metrics(router, handler)
+	handler = middlewares.Tracing(tracer, router, handler)
+	handler = middlewares.RequestID(logger, handler)
+	handler = middlewares.PassSignKey(config.SigningKey, handler)
+
+	serverConfig := config.Server
+	server := &http.Server{
+		Addr:              serverConfig.Addr(),
+		Handler:           handler,
+		ReadHeaderTimeout: serverConfig.ReadHeaderTimeout(),
+		ReadTimeout:       serverConfig.ReadTimeout(),
+		WriteTimeout:      serverConfig.WriteTimeout(),
+		IdleTimeout:       serverConfig.IdleTimeout(),
+		ErrorLog:          slog.NewLogLogger(logger.Handler(), slog.LevelError),
+	}
+
+	serverErr := make(chan error, 1)
+	go func() {
+		logger.Info("starting the server", "port", serverConfig.Port)
+		serverErr <- server.ListenAndServe()
+	}()
 
-	fmt.Println("Starting the server on port:" + os.Getenv("PORT"))
-	http.ListenAndServe(fmt.Sprint(":"+os.Getenv("PORT")), middlewares.PassSignKey(r))
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, os.Interrupt, syscall.SIGTERM)
+
+	exitCode := 0
+	select {
+	case err := <-serverErr:
+		logger.Error("server stopped", "error", err)
+		exitCode = 1
+	case sig := <-stop:
+		logger.Info("shutting down", "signal", sig.String())
+		// Load balancers only stop sending requests after a
+		// failed readiness check or more
+		healthCtrl.ShutDown()
+		time.Sleep(serverConfig.ShutdownDelay())
+	}
+
+	// In-flight requests are drained first, since they may still
+	// enqueue tasks and use the database
+	ctx, cancel := context.WithTimeout(context.Background(), serverConfig.ShutdownTimeout())
+	err = server.Shutdown(ctx)
+	if err != nil {
+		logger.Error("could not drain every request", "error", err)
+		exitCode = 1
+	}
+	cancel()
+	scheduler.Stop()
+	ctx, cancel = context.WithTimeout(context.Background(), serverConfig.JobsShutdownTimeout())
+	err = queue.Stop(ctx)
+	cancel()
+	if err != nil {
+		logger.Error("could not finish every background task", "error", err)
+		exitCode = 1
+	}
+	// Spans of the last requests and tasks
+	ctx, cancel = context.WithTimeout(context.Background(), 2*time.Second)
+	err = tracer.Shutdown(ctx)
+	cancel()
+	if err != nil {
+		logger.Error("could not export every span", "error", err)
+	}
+	err = services.Close()
+	if err != nil {
+		logger.Error("could not close the database", "error", err)
+		exitCode = 1
+	}
+	os.Exit(exitCode)
 }
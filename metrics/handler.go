@@ -0,0 +1,59 @@
+package metrics
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"net/http"
+)
+
+// Handler serves the metrics of the Default registry to scrapers
+// sending token as a bearer token. Without a token, metrics are
+// not served at all.
+func Handler(token string) http.Handler {
+	return handler(Default, token)
+}
+
+func handler(reg *Registry, token string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token == "" {
+			http.NotFound(w, r)
+			return
+		}
+		got := r.Header.Get("Authorization")
+		if subtle.ConstantTimeCompare([]byte(got), []byte("Bearer "+token)) != 1 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		reg.Write(w)
+	})
+}
+
+// RegisterDBStats registers gauges and counters of a database
+// connection pool, read from stats when served
+func RegisterDBStats(stats func() sql.DBStats) {
+	NewGaugeFunc("db_max_open_connections", "Maximum number of open connections to the database.", func() float64 {
+		return float64(stats().MaxOpenConnections)
+	})
+	NewGaugeFunc("db_open_connections", "Number of open connections to the database.", func() float64 {
+		return float64(stats().OpenConnections)
+	})
+	NewGaugeFunc("db_in_use_connections", "Number of connections currently in use.", func() float64 {
+		return float64(stats().InUse)
+	})
+	NewGaugeFunc("db_idle_connections", "Number of idle connections.", func() float64 {
+		return float64(stats().Idle)
+	})
+	NewCounterFunc("db_wait_count_total", "Number of connections waited for.", func() float64 {
+		return float64(stats().WaitCount)
+	})
+	NewCounterFunc("db_wait_duration_seconds_total", "Time spent waiting for a connection.", func() float64 {
+		return stats().WaitDuration.Seconds()
+	})
+	NewCounterFunc("db_max_idle_closed_total", "Connections closed because the idle pool was full.", func() float64 {
+		return float64(stats().MaxIdleClosed)
+	})
+	NewCounterFunc("db_max_lifetime_closed_total", "Connections closed because they reached their maximum lifetime.", func() float64 {
+		return float64(stats().MaxLifetimeClosed)
+	})
+}
@@ -0,0 +1,230 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// Registers the collectors with a registry of their own, instead
+// of Default which the New* functions use
+func newTestRegistry(collectors ...collector) *Registry {
+	reg := NewRegistry()
+	for _, c := range collectors {
+		reg.register(c)
+	}
+	return reg
+}
+
+func exposition(reg *Registry) string {
+	var buf bytes.Buffer
+	reg.Write(&buf)
+	return buf.String()
+}
+
+func TestCounterVec(t *testing.T) {
+	c := &CounterVec{
+		desc:   desc{metricName: "logins_total", help: "Logins.", kind: "counter", labels: []string{"method", "result"}},
+		values: make(map[string]*float64),
+	}
+	c.Inc("password", "success")
+	c.Inc("password", "success")
+	c.Add(0.5, "magic_link", "failure")
+
+	got := exposition(newTestRegistry(c))
+	want := `# HELP logins_total Logins.
+# TYPE logins_total counter
+logins_total{method="magic_link",result="failure"} 0.5
+logins_total{method="password",result="success"} 2
+`
+	if got != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestCounterVecPanics(t *testing.T) {
+	c := &CounterVec{
+		desc:   desc{metricName: "c", kind: "counter", labels: []string{"a"}},
+		values: make(map[string]*float64),
+	}
+	tests := []struct {
+		name string
+		fn   func()
+	}{
+		{"negative", func() { c.Add(-1, "x") }},
+		{"missing label", func() { c.Inc() }},
+		{"extra label", func() { c.Inc("x", "y") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			defer func() {
+				if recover() == nil {
+					t.Error("did not panic")
+				}
+			}()
+			tt.fn()
+		})
+	}
+}
+
+func TestGaugeVec(t *testing.T) {
+	g := &GaugeVec{
+		desc:   desc{metricName: "in_flight", help: "In flight.", kind: "gauge"},
+		values: make(map[string]*float64),
+	}
+	g.Inc()
+	g.Inc()
+	g.Dec()
+	g.Add(-3)
+
+	got := exposition(newTestRegistry(g))
+	want := `# HELP in_flight In flight.
+# TYPE in_flight gauge
+in_flight -2
+`
+	if got != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestHistogramVec(t *testing.T) {
+	h := &HistogramVec{
+		desc:    desc{metricName: "duration_seconds", help: "Duration.", kind: "histogram", labels: []string{"route"}},
+		buckets: []float64{0.1, 1},
+		series:  make(map[string]*histogram),
+	}
+	h.Observe(0.05, "/a")
+	h.Observe(0.1, "/a")
+	h.Observe(0.5, "/a")
+	h.Observe(2, "/a")
+
+	// Buckets are cumulative, and le="+Inf" counts everything
+	got := exposition(newTestRegistry(h))
+	want := `# HELP duration_seconds Duration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{route="/a",le="0.1"} 2
+duration_seconds_bucket{route="/a",le="1"} 3
+duration_seconds_bucket{route="/a",le="+Inf"} 4
+duration_seconds_sum{route="/a"} 2.65
+duration_seconds_count{route="/a"} 4
+`
+	if got != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestValueFunc(t *testing.T) {
+	value := 3.0
+	f := &valueFunc{
+		desc:  desc{metricName: "open_connections", help: "Open.", kind: "gauge"},
+		value: func() float64 { return value },
+	}
+	reg := newTestRegistry(f)
+
+	value = 5
+	if got := exposition(reg); !strings.HasSuffix(got, "\nopen_connections 5\n") {
+		t.Errorf("exposition =\n%s\nwant the value when written", got)
+	}
+}
+
+func TestEscaping(t *testing.T) {
+	c := &CounterVec{
+		desc:   desc{metricName: "c_total", help: "Help with \\ and\nnewline.", kind: "counter", labels: []string{"v"}},
+		values: make(map[string]*float64),
+	}
+	c.Inc("a \"quoted\" \\ value\nwith newline")
+
+	got := exposition(newTestRegistry(c))
+	want := `# HELP c_total Help with \\ and\nnewline.
+# TYPE c_total counter
+c_total{v="a \"quoted\" \\ value\nwith newline"} 1
+`
+	if got != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestFormatFloat(t *testing.T) {
+	tests := []struct {
+		f    float64
+		want string
+	}{
+		{0, "0"},
+		{1.5, "1.5"},
+		{1e21, "1e+21"},
+		{math.Inf(1), "+Inf"},
+		{math.Inf(-1), "-Inf"},
+		{math.NaN(), "NaN"},
+	}
+	for _, tt := range tests {
+		if got := formatFloat(tt.f); got != tt.want {
+			t.Errorf("formatFloat(%v) = %q, want %q", tt.f, got, tt.want)
+		}
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	b := &valueFunc{desc: desc{metricName: "b", help: "B.", kind: "gauge"}, value: func() float64 { return 2 }}
+	a := &valueFunc{desc: desc{metricName: "a", help: "A.", kind: "gauge"}, value: func() float64 { return 1 }}
+	reg := newTestRegistry(b, a)
+
+	// Families are sorted by name
+	got := exposition(reg)
+	want := "# HELP a A.\n# TYPE a gauge\na 1\n# HELP b B.\n# TYPE b gauge\nb 2\n"
+	if got != want {
+		t.Errorf("exposition =\n%s\nwant\n%s", got, want)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("registering a name twice did not panic")
+		}
+	}()
+	reg.register(&valueFunc{desc: desc{metricName: "a"}})
+}
+
+func TestHandler(t *testing.T) {
+	reg := newTestRegistry(&valueFunc{desc: desc{metricName: "up", help: "Up.", kind: "gauge"}, value: func() float64 { return 1 }})
+
+	tests := []struct {
+		name          string
+		token         string
+		authorization string
+		want          int
+	}{
+		{"token", "secret", "Bearer secret", http.StatusOK},
+		{"no token sent", "secret", "", http.StatusUnauthorized},
+		{"wrong token", "secret", "Bearer other", http.StatusUnauthorized},
+		{"no token set", "", "", http.StatusNotFound},
+		{"no token set, empty bearer", "", "Bearer ", http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/metrics", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			handler(reg, tt.token).ServeHTTP(rec, req)
+
+			if rec.Code != tt.want {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.want)
+			}
+			if tt.want != http.StatusOK {
+				if strings.Contains(rec.Body.String(), "up 1") {
+					t.Error("served metrics")
+				}
+				return
+			}
+			if got := rec.Header().Get("Content-Type"); !strings.HasPrefix(got, "text/plain; version=0.0.4") {
+				t.Errorf("Content-Type = %q, want the text exposition format", got)
+			}
+			if !strings.HasSuffix(rec.Body.String(), "\nup 1\n") {
+				t.Errorf("body =\n%s\nwant the metrics", rec.Body)
+			}
+		})
+	}
+}
@@ -0,0 +1,34 @@
+package middlewares
+
+import (
+	"mime"
+	"net/http"
+)
+
+// JSONBody returns a middleware for routes that read a JSON
+// body of at most maxBytes. Larger bodies are refused with
+// 413, and bodies that are not application/json with 415.
+// Requests without a body are let through.
+func JSONBody(maxBytes int64) func(http.HandlerFunc) http.HandlerFunc {
+	return func(next http.HandlerFunc) http.HandlerFunc {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.ContentLength > maxBytes {
+				sendErrorResponse(w, http.StatusRequestEntityTooLarge, "Request body is too large.")
+				return
+			}
+			// The length is unknown (-1) for chunked bodies
+			if r.ContentLength != 0 {
+				mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+				if err != nil || mediaType != "application/json" {
+					sendErrorResponse(w, http.StatusUnsupportedMediaType, "Content-Type must be application/json.")
+					return
+				}
+			}
+
+			// Reading past maxBytes fails, for bodies that did
+			// not tell their length
+			r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+			next(w, r)
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"go_rest_pg_starter/auth"
+)
+
+var (
+	errCSRFTokenInvalid = errors.New("middlewares: CSRF token is not valid")
+	errSessionEnded     = errors.New("middlewares: session was revoked or has expired")
+	errTokenInvalid     = errors.New("middlewares: token is not valid")
+)
+
+// Requests without a bearer token are signed in with the
+// remember token cookie, if they carry one
+func usesRememberCookie(r *http.Request) bool {
+	if r.Header.Get("Authorization") != "" {
+		return false
+	}
+	_, err := r.Cookie(auth.RememberCookie)
+	return err == nil
+}
+
+// Looks up the session of the remember token cookie and its
+// user. Requests that could change something must echo the CSRF
+// token in a header, so that other sites cannot make them on the
+// user's behalf.
+func (us *User) cookieUser(r *http.Request, signingKey string) (*UserWithToken, error) {
+	cookie, err := r.Cookie(auth.RememberCookie)
+	if err != nil {
+		return nil, err
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+	default:
+		if !auth.ValidCSRFToken(r.Header.Get(auth.CSRFHeader), cookie.Value, signingKey) {
+			return nil, errCSRFTokenInvalid
+		}
+	}
+
+	session, err := us.Sessions.WithContext(r.Context()).GetByToken(cookie.Value)
+	if err != nil {
+		return nil, err
+	}
+	user, err := us.UserService.WithContext(r.Context()).GetById(session.UserID)
+	if err != nil {
+		return nil, err
+	}
+	if !us.useSession(r.Context(), session) {
+		return nil, errSessionEnded
+	}
+	withToken := newUserWithToken(user, session)
+	withToken.ViaCookie = true
+	return withToken, nil
+}
@@ -0,0 +1,121 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"go_rest_pg_starter/config"
+
+	"github.com/gorilla/mux"
+)
+
+// Headers browsers let frontends read from responses
+var corsExposedHeaders = []string{RequestIDHeader}
+
+// CORS lets browsers call the API from the allowed origins. Since
+// routes of router only accept their own methods, preflight
+// requests are answered here for any route that serves the
+// requested method, and passed on otherwise.
+// Requests without an allowed Origin get no CORS headers.
+func CORS(cfg config.CORSConfig, router *mux.Router, next http.Handler) http.Handler {
+	methods := strings.Join(cfg.AllowedMethods, ", ")
+	headers := strings.Join(cfg.AllowedHeaders, ", ")
+	exposed := strings.Join(corsExposedHeaders, ", ")
+	maxAge := strconv.Itoa(int(cfg.MaxAge().Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		// The response depends on the origin, whether allowed or not
+		w.Header().Add("Vary", "Origin")
+		allowOrigin, ok := corsAllowOrigin(cfg, origin)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method == http.MethodOptions && requestedMethod != "" {
+			if !corsAllowsMethod(cfg, requestedMethod) || !routeServes(router, r, requestedMethod) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			w.Header().Add("Vary", "Access-Control-Request-Method")
+			w.Header().Add("Vary", "Access-Control-Request-Headers")
+			setCORSOrigin(w, cfg, allowOrigin)
+			w.Header().Set("Access-Control-Allow-Methods", methods)
+			if headers != "" {
+				w.Header().Set("Access-Control-Allow-Headers", headers)
+			}
+			w.Header().Set("Access-Control-Max-Age", maxAge)
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		setCORSOrigin(w, cfg, allowOrigin)
+		w.Header().Set("Access-Control-Expose-Headers", exposed)
+		next.ServeHTTP(w, r)
+	})
+}
+
+func setCORSOrigin(w http.ResponseWriter, cfg config.CORSConfig, allowOrigin string) {
+	w.Header().Set("Access-Control-Allow-Origin", allowOrigin)
+	if cfg.AllowCredentials {
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+	}
+}
+
+// The Access-Control-Allow-Origin value for origin, if allowed:
+// "*" when any origin is, the origin itself otherwise
+func corsAllowOrigin(cfg config.CORSConfig, origin string) (string, bool) {
+	origin = strings.ToLower(origin)
+	for _, allowed := range cfg.AllowedOrigins {
+		allowed = strings.ToLower(allowed)
+		if allowed == "*" {
+			return "*", true
+		}
+		if originMatches(allowed, origin) {
+			return origin, true
+		}
+	}
+	return "", false
+}
+
+// Whether origin is allowed, or is a subdomain allowed by
+// a "scheme://*.domain" pattern
+func originMatches(allowed, origin string) bool {
+	i := strings.Index(allowed, "*.")
+	if i < 0 {
+		return allowed == origin
+	}
+	prefix, suffix := allowed[:i], allowed[i+1:]
+	if len(origin) <= len(prefix)+len(suffix) ||
+		!strings.HasPrefix(origin, prefix) || !strings.HasSuffix(origin, suffix) {
+		return false
+	}
+	// Only host labels may stand in for the wildcard
+	subdomain := origin[len(prefix) : len(origin)-len(suffix)]
+	return !strings.ContainsAny(subdomain, "/:@?#")
+}
+
+func corsAllowsMethod(cfg config.CORSConfig, method string) bool {
+	for _, allowed := range cfg.AllowedMethods {
+		if strings.EqualFold(allowed, method) {
+			return true
+		}
+	}
+	return false
+}
+
+// Whether a route of router serves the request's path
+// with the given method
+func routeServes(router *mux.Router, r *http.Request, method string) bool {
+	probe := r.Clone(r.Context())
+	probe.Method = strings.ToUpper(method)
+	var match mux.RouteMatch
+	return router.Match(probe, &match) && match.Route != nil
+}
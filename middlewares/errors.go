@@ -0,0 +1,17 @@
+package middlewares
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Same shape as the error responses of the controllers
+type errorMessage struct {
+	Message string `json:"message"`
+}
+
+func sendErrorResponse(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(errorMessage{Message: message})
+}
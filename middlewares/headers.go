@@ -0,0 +1,32 @@
+package middlewares
+
+import (
+	"net/http"
+)
+
+// Browsers keep to HTTPS for the host for a year once they saw it
+const hstsValue = "max-age=31536000; includeSubDomains"
+
+// SecurityHeaders sets headers that keep browsers from sniffing
+// content types or framing responses. hsts, for production
+// behind HTTPS, also tells them to only use HTTPS.
+func SecurityHeaders(hsts bool, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.Header().Set("X-Frame-Options", "DENY")
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		if hsts {
+			w.Header().Set("Strict-Transport-Security", hstsValue)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Middleware to keep responses with tokens or account details
+// out of browser and proxy caches
+func NoStore(next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Cache-Control", "no-store")
+		next(w, r)
+	})
+}
@@ -4,8 +4,9 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"time"
 
-	"go_rest_pg_starter/config"
+	"go_rest_pg_starter/logging"
 	"go_rest_pg_starter/models"
 
 	jwt "github.com/dgrijalva/jwt-go"
@@ -14,17 +15,30 @@ import (
 
 type User struct {
 	models.UserService
-}
-
-func getSignKey() string {
-	cfg := config.GetConfig()
-	return cfg.SigningKey
+	Sessions models.SessionService
+	// Key the JWTs are signed with
+	SigningKey string
 }
 
 type UserWithToken struct {
 	ID        uint   `gorm:"primary_key"`
 	Username  string `gorm:"not null; unique_index"`
 	UserEmail string `gorm:"not null; unique_index"`
+	Role      string `json:"-"`
+	// The session the request's token was issued for
+	SessionID uint `json:"-"`
+	// Set when signed in with the remember token cookie
+	ViaCookie bool `json:"-"`
+}
+
+func newUserWithToken(user *models.User, session *models.Session) *UserWithToken {
+	return &UserWithToken{
+		ID:        user.ID,
+		UserEmail: user.Email,
+		Username:  user.Username,
+		Role:      user.Role,
+		SessionID: session.ID,
+	}
 }
 
 func LookUpUserFromContext(ctx context.Context) *UserWithToken {
@@ -38,8 +52,7 @@ func LookUpUserFromContext(ctx context.Context) *UserWithToken {
 	return nil
 }
 
-func PassSignKey(h http.Handler) http.Handler {
-	signingKey := getSignKey()
+func PassSignKey(signingKey string, h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
 		ctx = context.WithValue(ctx, "signingKey", signingKey)
@@ -48,54 +61,127 @@ func PassSignKey(h http.Handler) http.Handler {
 	})
 }
 
-// Middleware to check JWT
+// Middleware to attach the user when the request carries a valid JWT
+// or remember token cookie. Unlike RequireUser, anonymous requests
+// are let through.
+func (us *User) OptionalUser(next http.HandlerFunc) http.HandlerFunc {
+	signingKey := us.SigningKey
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, err := us.requestUser(r, signingKey)
+		if err != nil {
+			next(w, r)
+			return
+		}
+		next(w, withUser(r, user))
+	})
+}
+
+// Middleware to check JWT, or the remember token cookie
 func (us *User) RequireUser(next http.HandlerFunc) http.HandlerFunc {
-	signingKey := getSignKey()
+	signingKey := us.SigningKey
 
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		token, err := request.ParseFromRequest(r, request.AuthorizationHeaderExtractor,
-			func(token *jwt.Token) (interface{}, error) {
-				return []byte(signingKey), nil
-			})
-
-		if err == nil {
-			if token.Valid {
-				claims, ok := token.Claims.(jwt.MapClaims)
-				if !ok || !token.Valid {
-					w.WriteHeader(http.StatusUnauthorized)
-					fmt.Fprint(w, "Token is not valid")
-				}
-
-				// We want to pass username extracted from JWT token to next handler:
-				// Take the context out from the request
-				ctx := r.Context()
-				uid := claims["logged_in_user_id"]
-
-				user, err := us.UserService.GetById(uint(uid.(float64)))
-				if err != nil {
-					next(w, r)
-					return
-				}
-
-				mappedUser := &UserWithToken{
-					ID:        user.ID,
-					UserEmail: user.Email,
-					Username:  user.Username,
-				}
-
-				ctx = context.WithValue(ctx, "logged_in_user", mappedUser)
-
-				// Get new http.Request with the new context
-				r = r.WithContext(ctx)
-
-				next(w, r)
-			} else {
-				w.WriteHeader(http.StatusUnauthorized)
-				fmt.Fprint(w, "Token is not valid")
-			}
-		} else {
+		user, err := us.requestUser(r, signingKey)
+		switch err {
+		case nil:
+			// Get new http.Request with the user in its context
+			next(w, withUser(r, user))
+		case errCSRFTokenInvalid:
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "CSRF token is not valid")
+		case request.ErrNoTokenInRequest:
 			w.WriteHeader(http.StatusUnauthorized)
 			fmt.Fprint(w, "Unauthorized access to this resource")
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			fmt.Fprint(w, "Token is not valid")
+		}
+	})
+}
+
+// Looks up the user signed in with the remember token cookie,
+// or with the JWT of the Authorization header
+func (us *User) requestUser(r *http.Request, signingKey string) (*UserWithToken, error) {
+	if usesRememberCookie(r) {
+		return us.cookieUser(r, signingKey)
+	}
+	return us.bearerUser(r, signingKey)
+}
+
+// Looks up the user and session of the JWT in the Authorization
+// header. It returns request.ErrNoTokenInRequest without one.
+func (us *User) bearerUser(r *http.Request, signingKey string) (*UserWithToken, error) {
+	token, err := request.ParseFromRequest(r, request.AuthorizationHeaderExtractor,
+		func(token *jwt.Token) (interface{}, error) {
+			return []byte(signingKey), nil
+		})
+	if err == request.ErrNoTokenInRequest {
+		return nil, err
+	}
+	if err != nil || !token.Valid {
+		return nil, errTokenInvalid
+	}
+
+	// We want to pass the user of the JWT token to next handler
+	claims, ok := token.Claims.(jwt.MapClaims)
+	if !ok {
+		return nil, errTokenInvalid
+	}
+	uid, ok := claims["logged_in_user_id"].(float64)
+	if !ok {
+		return nil, errTokenInvalid
+	}
+
+	user, err := us.UserService.WithContext(r.Context()).GetById(uint(uid))
+	if err != nil {
+		return nil, err
+	}
+	session, ok := us.activeSession(r.Context(), claims, user)
+	if !ok {
+		return nil, errSessionEnded
+	}
+	return newUserWithToken(user, session), nil
+}
+
+// Looks up the session the token was issued for. Tokens of
+// revoked or expired sessions, or without a session, are no
+// longer accepted. The session is marked as seen otherwise.
+func (us *User) activeSession(ctx context.Context, claims jwt.MapClaims, user *models.User) (*models.Session, bool) {
+	sid, ok := claims["sid"].(float64)
+	if !ok {
+		return nil, false
+	}
+	session, err := us.Sessions.WithContext(ctx).GetById(uint(sid))
+	if err != nil || session.UserID != user.ID {
+		return nil, false
+	}
+	return session, us.useSession(ctx, session)
+}
+
+// Reports whether the session is still active, and marks it as
+// seen if so
+func (us *User) useSession(ctx context.Context, session *models.Session) bool {
+	now := time.Now()
+	if !session.IsActive(now) {
+		return false
+	}
+	err := us.Sessions.WithContext(ctx).Touch(session, now)
+	if err != nil {
+		logging.FromContext(ctx).Error("could not update session", "session_id", session.ID, "error", err)
+	}
+	return true
+}
+
+// Middleware to only let admin users through
+func (us *User) RequireAdmin(next http.HandlerFunc) http.HandlerFunc {
+	return us.RequireUser(func(w http.ResponseWriter, r *http.Request) {
+		user := LookUpUserFromContext(r.Context())
+		if user == nil || user.Role != models.RoleAdmin {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "Forbidden")
+			return
 		}
+		next(w, r)
 	})
 }
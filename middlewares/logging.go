@@ -0,0 +1,104 @@
+package middlewares
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"go_rest_pg_starter/logging"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	RequestIDHeader = "X-Request-ID"
+	// Longer incoming request IDs are replaced
+	maxRequestIDLength = 128
+)
+
+// The user signed in for the request, recorded for the access
+// log once the auth middlewares have run
+type requestUser struct {
+	id uint
+}
+
+type requestUserKey struct{}
+
+// RequestID gives every request an ID, taken from the
+// X-Request-ID header when the client or proxy set a usable
+// one. The ID is sent back, and the logger of the request
+// context adds it to every line.
+func RequestID(logger *slog.Logger, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := logging.WithRequestID(r.Context(), id)
+		ctx = logging.WithLogger(ctx, logger.With("request_id", id))
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// AccessLog logs a line for each request once handled, with the
+// template of the route of router it matches. It expects
+// RequestID to run first.
+func AccessLog(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		user := &requestUser{}
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(context.WithValue(r.Context(), requestUserKey{}, user)))
+
+		attrs := []any{
+			slog.String("method", r.Method),
+			slog.String("route", routeTemplate(router, r)),
+			slog.Int("status", rec.status),
+			slog.Float64("duration_ms", float64(time.Since(start).Microseconds())/1000),
+			slog.Int64("bytes", rec.bytes),
+			slog.String("remote_addr", r.RemoteAddr),
+		}
+		if user.id != 0 {
+			attrs = append(attrs, slog.Uint64("user_id", uint64(user.id)))
+		}
+		logging.FromContext(r.Context()).Info("request", attrs...)
+	})
+}
+
+// Attaches the signed in user to the request, and records it
+// for the access log
+func withUser(r *http.Request, user *UserWithToken) *http.Request {
+	if recorded, ok := r.Context().Value(requestUserKey{}).(*requestUser); ok {
+		recorded.id = user.ID
+	}
+	ctx := context.WithValue(r.Context(), "logged_in_user", user)
+	return r.WithContext(ctx)
+}
+
+// Request IDs end up in logs and headers, so only short ones
+// made of letters, digits, dashes, dots and underscores are kept
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for _, c := range id {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9':
+		case c == '-', c == '.', c == '_':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+func newRequestID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
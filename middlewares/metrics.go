@@ -0,0 +1,39 @@
+package middlewares
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"go_rest_pg_starter/metrics"
+
+	"github.com/gorilla/mux"
+)
+
+var (
+	httpRequestsTotal = metrics.NewCounterVec("http_requests_total",
+		"HTTP requests handled, by route template and status code.", "method", "route", "code")
+	httpRequestDuration = metrics.NewHistogramVec("http_request_duration_seconds",
+		"Time taken to handle HTTP requests.", metrics.DefaultBuckets, "method", "route")
+	httpRequestsInFlight = metrics.NewGaugeVec("http_requests_in_flight",
+		"HTTP requests being handled.")
+)
+
+// Metrics counts requests by the template of the route of router
+// they match, so that ids in paths do not make a series each.
+// Unmatched requests count as "unmatched".
+func Metrics(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route := routeTemplate(router, r)
+
+		httpRequestsInFlight.Inc()
+		defer httpRequestsInFlight.Dec()
+
+		start := time.Now()
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r)
+
+		httpRequestsTotal.Inc(r.Method, route, strconv.Itoa(rec.status))
+		httpRequestDuration.Observe(time.Since(start).Seconds(), r.Method, route)
+	})
+}
@@ -0,0 +1,49 @@
+package middlewares
+
+import (
+	"fmt"
+	"net/http"
+	"runtime/debug"
+
+	"go_rest_pg_starter/logging"
+	"go_rest_pg_starter/metrics"
+
+	"github.com/gorilla/mux"
+)
+
+var httpPanicsTotal = metrics.NewCounterVec("http_panics_total",
+	"Panics recovered while handling HTTP requests, by route template.", "route")
+
+// Recover turns a panic in a handler into a 500 response, instead
+// of the connection being dropped. The panic is logged with its
+// stack, along with the request id of the logger in the context.
+func Recover(router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rec := newResponseRecorder(w)
+		defer func() {
+			v := recover()
+			if v == nil {
+				return
+			}
+			// Handlers abort on purpose with this one
+			if v == http.ErrAbortHandler {
+				panic(v)
+			}
+
+			route := routeTemplate(router, r)
+			httpPanicsTotal.Inc(route)
+			logging.FromContext(r.Context()).Error("panic while handling request",
+				"route", route,
+				"panic", fmt.Sprint(v),
+				"stack", string(debug.Stack()))
+
+			// Too late to change the response once it was started
+			if rec.wroteHeader {
+				return
+			}
+			sendErrorResponse(w, http.StatusInternalServerError, "Whoops! Something went wrong.")
+		}()
+
+		next.ServeHTTP(rec, r)
+	})
+}
@@ -0,0 +1,41 @@
+package middlewares
+
+import (
+	"errors"
+	"net/http"
+
+	"go_rest_pg_starter/logging"
+	"go_rest_pg_starter/tracing"
+
+	"github.com/gorilla/mux"
+)
+
+// Tracing starts a span per request, named after the route of
+// router it matches, as a child of the span of the traceparent
+// header if any. The trace ID is added to the request's logs.
+func Tracing(tracer *tracing.Tracer, router *mux.Router, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if parent, ok := tracing.ParseTraceparent(r.Header.Get("traceparent")); ok {
+			ctx = tracing.ContextWithRemoteParent(ctx, parent)
+		}
+
+		route := routeTemplate(router, r)
+		ctx, span := tracer.Start(ctx, r.Method+" "+route, tracing.SpanKindServer)
+		defer span.End()
+		span.SetAttribute("http.request.method", r.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("user_agent.original", r.UserAgent())
+
+		logger := logging.FromContext(ctx).With("trace_id", span.Context().TraceID.String())
+		ctx = logging.WithLogger(ctx, logger)
+
+		rec := newResponseRecorder(w)
+		next.ServeHTTP(rec, r.WithContext(ctx))
+
+		span.SetAttribute("http.response.status_code", rec.status)
+		if rec.status >= http.StatusInternalServerError {
+			span.SetError(errors.New(http.StatusText(rec.status)))
+		}
+	})
+}
@@ -0,0 +1,102 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// DataExport is an archive of a user's data kept in the
+// blob store until it expires
+type DataExport struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	UserID    uint   `gorm:"not null; index"`
+	BlobKey   string `gorm:"not null; unique_index"`
+}
+
+// AccountData is everything stored about a user, as put
+// in their data export
+type AccountData struct {
+	User        User
+	Posts       []Post
+	Attachments []Attachment
+	Reactions   []Reaction
+	Following   []User
+	Followers   []User
+}
+
+// AccountService is a set of methods used to work with
+// everything tied to a user account at once
+type AccountService interface {
+	// Delete soft-deletes the user and their posts, which are then
+	// purged after the retention period, and removes everything else
+	// tied to the account right away. The user's personal details are
+	// erased. It returns the blob keys of the user's data exports so
+	// that they can be removed from the store.
+	Delete(userID uint) ([]string, error)
+	// Collect gathers all the data of the user, including deleted posts
+	Collect(userID uint) (*AccountData, error)
+	GetExport(id uint) (*DataExport, error)
+	CreateExport(export *DataExport) error
+	// Deletes the records of exports created before the given
+	// time and returns their blob keys
+	DeleteExportsBefore(before time.Time) ([]string, error)
+	// WithContext returns the service for queries made on
+	// behalf of ctx, see WithTracer
+	WithContext(ctx context.Context) AccountService
+}
+
+func NewAccountService(db *gorm.DB) AccountService {
+	return &accountService{
+		accountDB: &accountValidator{
+			accountDB: &accountGorm{
+				db: db,
+			},
+		},
+		db: db,
+	}
+}
+
+var _ AccountService = &accountService{}
+
+type accountService struct {
+	accountDB accountDB
+	db        *gorm.DB
+}
+
+func (as *accountService) WithContext(ctx context.Context) AccountService {
+	return NewAccountService(withContext(as.db, ctx))
+}
+
+// The username, email address and profile of a deleted user are
+// replaced, so that nothing identifying them is kept while their
+// posts wait to be purged, and so that the username and address
+// can be signed up with again. The placeholders cannot be picked
+// by anyone signing up.
+func (as *accountService) Delete(userID uint) ([]string, error) {
+	erased := User{
+		Username: fmt.Sprintf("deleted-%d", userID),
+		Email:    fmt.Sprintf("deleted-%d", userID),
+	}
+	erased.ID = userID
+	return as.accountDB.Erase(&erased)
+}
+
+func (as *accountService) Collect(userID uint) (*AccountData, error) {
+	return as.accountDB.Collect(userID)
+}
+
+func (as *accountService) GetExport(id uint) (*DataExport, error) {
+	return as.accountDB.GetExport(id)
+}
+
+func (as *accountService) CreateExport(export *DataExport) error {
+	return as.accountDB.CreateExport(export)
+}
+
+func (as *accountService) DeleteExportsBefore(before time.Time) ([]string, error) {
+	return as.accountDB.DeleteExportsBefore(before)
+}
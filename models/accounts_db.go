@@ -0,0 +1,155 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type accountDB interface {
+	// Soft-deletes the user of erased.ID, replacing their username
+	// and email by those of erased and clearing the rest
+	// of their details, see AccountService.Delete
+	Erase(erased *User) ([]string, error)
+	Collect(userID uint) (*AccountData, error)
+	GetExport(id uint) (*DataExport, error)
+	CreateExport(export *DataExport) error
+	DeleteExportsBefore(before time.Time) ([]string, error)
+}
+
+type accountGorm struct {
+	db *gorm.DB
+}
+
+var _ accountDB = &accountGorm{}
+
+func (ag *accountGorm) Erase(erased *User) ([]string, error) {
+	userID := erased.ID
+	var blobKeys []string
+	err := transaction(ag.db, func(tx *gorm.DB) error {
+		err := deleteReactionsByUsers(tx, "SELECT id FROM users WHERE id = ?", userID)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("follower_id = ? OR followee_id = ?", userID, userID).Delete(&Follow{}).Error
+		if err != nil {
+			return err
+		}
+
+		// Signs the user out everywhere too
+		for _, model := range []interface{}{&passwordReset{}, &emailChange{}, &magicLink{}, &Identity{}, &Session{}} {
+			err = tx.Unscoped().Where("user_id = ?", userID).Delete(model).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Model(&DataExport{}).Where("user_id = ?", userID).Pluck("blob_key", &blobKeys).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Where("user_id = ?", userID).Delete(&DataExport{}).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Where("user_id = ?", userID).Delete(&Post{}).Error
+		if err != nil {
+			return err
+		}
+
+		db := tx.Model(&User{}).Where("id = ?", userID).UpdateColumns(map[string]interface{}{
+			"deleted_at":     time.Now(),
+			"username":       erased.Username,
+			"email":          erased.Email,
+			"password_hash":  "",
+			"external_login": false,
+			"display_name":   "",
+			"bio":            "",
+			"avatar_url":     "",
+		})
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blobKeys, nil
+}
+
+func (ag *accountGorm) Collect(userID uint) (*AccountData, error) {
+	var data AccountData
+	err := First(ag.db.Where("id = ?", userID), &data.User)
+	if err != nil {
+		return nil, err
+	}
+
+	err = ag.db.Unscoped().Where("user_id = ?", userID).Order("id").Find(&data.Posts).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = ag.db.Where("user_id = ?", userID).Order("id").Find(&data.Attachments).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = ag.db.Where("user_id = ?", userID).Order("id").Find(&data.Reactions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = ag.db.Select("users.*").
+		Joins("JOIN follows ON follows.followee_id = users.id").
+		Where("follows.follower_id = ?", userID).
+		Order("follows.id").
+		Find(&data.Following).Error
+	if err != nil {
+		return nil, err
+	}
+
+	err = ag.db.Select("users.*").
+		Joins("JOIN follows ON follows.follower_id = users.id").
+		Where("follows.followee_id = ?", userID).
+		Order("follows.id").
+		Find(&data.Followers).Error
+	if err != nil {
+		return nil, err
+	}
+
+	return &data, nil
+}
+
+func (ag *accountGorm) GetExport(id uint) (*DataExport, error) {
+	var export DataExport
+	err := First(ag.db.Where("id = ?", id), &export)
+	if err != nil {
+		return nil, err
+	}
+	return &export, nil
+}
+
+func (ag *accountGorm) CreateExport(export *DataExport) error {
+	return ag.db.Create(export).Error
+}
+
+func (ag *accountGorm) DeleteExportsBefore(before time.Time) ([]string, error) {
+	var blobKeys []string
+	err := transaction(ag.db, func(tx *gorm.DB) error {
+		err := tx.Model(&DataExport{}).Where("created_at < ?", before).Pluck("blob_key", &blobKeys).Error
+		if err != nil {
+			return err
+		}
+		return exec(tx, "DELETE FROM data_exports WHERE created_at < ?", before).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blobKeys, nil
+}
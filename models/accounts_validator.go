@@ -0,0 +1,39 @@
+package models
+
+type accountValidator struct {
+	accountDB
+}
+
+func (av *accountValidator) Erase(erased *User) ([]string, error) {
+	if erased.ID <= 0 {
+		return nil, ErrInvalidID
+	}
+	if erased.Username == "" || erased.Email == "" {
+		return nil, ErrUserErasureInvalid
+	}
+	return av.accountDB.Erase(erased)
+}
+
+func (av *accountValidator) Collect(userID uint) (*AccountData, error) {
+	if userID <= 0 {
+		return nil, ErrInvalidID
+	}
+	return av.accountDB.Collect(userID)
+}
+
+func (av *accountValidator) GetExport(id uint) (*DataExport, error) {
+	if id <= 0 {
+		return nil, ErrInvalidID
+	}
+	return av.accountDB.GetExport(id)
+}
+
+func (av *accountValidator) CreateExport(export *DataExport) error {
+	if export.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	if export.BlobKey == "" {
+		return ErrExportInvalid
+	}
+	return av.accountDB.CreateExport(export)
+}
@@ -0,0 +1,53 @@
+package models
+
+import (
+	"context"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Attachment is a file uploaded to a post. The file itself
+// lives in a blob store under BlobKey.
+type Attachment struct {
+	gorm.Model
+	PostID       uint   `gorm:"not null; index"`
+	UserID       uint   `gorm:"not null"`
+	FileName     string `gorm:"not null"`
+	ContentType  string `gorm:"not null"`
+	Size         int64  `gorm:"not null"`
+	BlobKey      string `gorm:"not null; unique_index"`
+	ThumbnailKey string `gorm:"not null; default:''"`
+	// Content type of the thumbnail, if there is one
+	ThumbnailContentType string `gorm:"not null; default:''"`
+}
+
+// AttachmentService is a set of methods used to manipulate
+// and work with the attachments of posts
+type AttachmentService interface {
+	AttachmentDB
+	// WithContext returns the service for queries made on
+	// behalf of ctx, see WithTracer
+	WithContext(ctx context.Context) AttachmentService
+}
+
+func NewAttachmentService(db *gorm.DB) AttachmentService {
+	return &attachmentService{
+		AttachmentDB: &attachmentValidator{
+			AttachmentDB: &attachmentGorm{
+				db: db,
+			},
+		},
+		db: db,
+	}
+}
+
+var _ AttachmentService = &attachmentService{}
+
+type attachmentService struct {
+	AttachmentDB
+	db *gorm.DB
+}
+
+func (as *attachmentService) WithContext(ctx context.Context) AttachmentService {
+	return NewAttachmentService(withContext(as.db, ctx))
+}
@@ -0,0 +1,46 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+type attachmentGorm struct {
+	db *gorm.DB
+}
+
+var _ AttachmentDB = &attachmentGorm{}
+
+type AttachmentDB interface {
+	GetOneById(id uint) (*Attachment, error)
+	GetAllByPostId(postId uint) ([]Attachment, error)
+	Create(attachment *Attachment) error
+	Delete(id uint) error
+}
+
+func (ag *attachmentGorm) GetOneById(id uint) (*Attachment, error) {
+	var attachment Attachment
+	db := ag.db.Where("id = ?", id)
+	err := First(db, &attachment)
+	if err != nil {
+		return nil, err
+	}
+	return &attachment, nil
+}
+
+func (ag *attachmentGorm) GetAllByPostId(postId uint) ([]Attachment, error) {
+	var attachments []Attachment
+	db := ag.db.Where("post_id = ?", postId).Order("id")
+	err := db.Find(&attachments).Error
+	if err != nil {
+		return nil, err
+	}
+	return attachments, nil
+}
+
+func (ag *attachmentGorm) Create(attachment *Attachment) error {
+	return ag.db.Create(attachment).Error
+}
+
+// Attachments are deleted for good, their blobs are gone too
+func (ag *attachmentGorm) Delete(id uint) error {
+	attachment := &Attachment{Model: gorm.Model{ID: id}}
+	return ag.db.Unscoped().Delete(attachment).Error
+}
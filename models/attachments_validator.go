@@ -0,0 +1,84 @@
+package models
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode/utf8"
+)
+
+type attachmentValidator struct {
+	AttachmentDB
+}
+
+func (av *attachmentValidator) Create(attachment *Attachment) error {
+	err := attachmentValidationFuncs(attachment,
+		av.requireIDs,
+		av.normalizeFileName,
+		av.requireBlob)
+	if err != nil {
+		return err
+	}
+	return av.AttachmentDB.Create(attachment)
+}
+
+func (av *attachmentValidator) Delete(id uint) error {
+	if id <= 0 {
+		return ErrInvalidID
+	}
+	return av.AttachmentDB.Delete(id)
+}
+
+func (av *attachmentValidator) requireIDs(attachment *Attachment) error {
+	if attachment.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	if attachment.PostID <= 0 {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+// Only the base name of the uploaded file is kept, without
+// control characters, and cut to 255 characters
+func (av *attachmentValidator) normalizeFileName(attachment *Attachment) error {
+	name := filepath.Base(strings.Replace(attachment.FileName, "\\", "/", -1))
+	name = strings.Map(func(r rune) rune {
+		if r < 0x20 || r == 0x7f || r == '"' {
+			return -1
+		}
+		return r
+	}, name)
+	if name == "." || name == "/" {
+		name = ""
+	}
+	if utf8.RuneCountInString(name) > 255 {
+		name = string([]rune(name)[:255])
+	}
+	if name == "" {
+		name = "file"
+	}
+	attachment.FileName = name
+	return nil
+}
+
+func (av *attachmentValidator) requireBlob(attachment *Attachment) error {
+	if attachment.BlobKey == "" || attachment.ContentType == "" {
+		return ErrAttachmentInvalid
+	}
+	if (attachment.ThumbnailKey == "") != (attachment.ThumbnailContentType == "") {
+		return ErrAttachmentInvalid
+	}
+	return nil
+}
+
+type attachmentValidationFunc func(*Attachment) error
+
+func attachmentValidationFuncs(attachment *Attachment, funcs ...attachmentValidationFunc) error {
+	for _, fn := range funcs {
+		err := fn(attachment)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -13,15 +13,41 @@ const (
 	ErrEmailRequired          modelError   = "models: Email is required"
 	ErrEmailInvalid           modelError   = "models: Email is not valid"
 	ErrEmailTaken             modelError   = "models: Email is already taken"
+	ErrUsernameRequired       modelError   = "models: Username is required"
+	ErrUsernameInvalid        modelError   = "models: Username must be 3 to 30 lowercase letters, digits or underscores, starting with a letter"
+	ErrUsernameTaken          modelError   = "models: Username is already taken"
+	ErrDisplayNameTooLong     modelError   = "models: Display name must be at most 50 characters long"
+	ErrBioTooLong             modelError   = "models: Bio must be at most 280 characters long"
+	ErrAvatarURLInvalid       modelError   = "models: Avatar URL must be a valid http or https URL"
 	ErrPasswordRequired       modelError   = "models: Password is required"
-	ErrPasswordTooShort       modelError   = "models: Password must be at least 8 characters long"
-	ErrTokenRequired          privateError = "models: Token is required"
-	ErrTokenTooShort          privateError = "models: Token must be at least 32 bytes"
+	ErrPasswordPersonal       modelError   = "models: Password must not contain your username or email"
+	ErrPasswordCommon         modelError   = "models: Password is too common, please pick another one"
+	ErrPasswordBreached       modelError   = "models: Password appeared in a data breach, please pick another one"
+	ErrPasswordHashInvalid    privateError = "models: Password hash is malformed or uses an unknown pepper"
 	ErrUserIDRequired         privateError = "models: UserID is required"
 	ErrTokenInvalid           modelError   = "models: token provided is not valid"
 	ErrServiceRequired        privateError = "models: Service is required"
 	ErrTitleRequired          modelError   = "models: Title is required"
 	ErrDescRequired           modelError   = "models: Description is required"
+	ErrStatusInvalid          modelError   = "models: Status must be one of draft, scheduled, published or archived"
+	ErrPublishAtRequired      modelError   = "models: Publish time is required for scheduled posts"
+	ErrReactionKindInvalid    modelError   = "models: Reaction must be one of like, love, laugh or wow"
+	ErrFollowSelf             modelError   = "models: You cannot follow yourself"
+	ErrAttachmentInvalid      privateError = "models: Attachment must have a blob key and content type, and thumbnails a content type"
+	ErrSessionExpiryRequired  privateError = "models: Session expiry is required"
+	ErrUserErasureInvalid     privateError = "models: Erased user must have placeholder username and email"
+	ErrExportInvalid          privateError = "models: Data export must have a blob key"
+	ErrIdentityInvalid        privateError = "models: Identity must have a provider and subject"
+	ErrIdentityTaken          modelError   = "models: This account is already linked to another user"
+	ErrIdentityAlreadyLinked  modelError   = "models: An account of this provider is already linked, unlink it first"
+	ErrIdentityUnverified     modelError   = "models: The provider did not confirm your email address"
+	ErrIdentityEmailTaken     modelError   = "models: An account already uses this email, sign in to it and link this provider instead"
+)
+
+// Page sizes for list queries
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
 )
 
 func First(db *gorm.DB, dst interface{}) error {
@@ -32,6 +58,39 @@ func First(db *gorm.DB, dst interface{}) error {
 	return err
 }
 
+// Runs fn inside a database transaction, committing when it
+// returns nil and rolling back otherwise
+func transaction(db *gorm.DB, fn func(tx *gorm.DB) error) error {
+	tx := db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	err := fn(tx)
+	if err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// Clamps a requested page size to (0, MaxPageSize]
+func normalizeLimit(limit int) int {
+	if limit <= 0 {
+		return DefaultPageSize
+	}
+	if limit > MaxPageSize {
+		return MaxPageSize
+	}
+	return limit
+}
+
+func normalizeOffset(offset int) int {
+	if offset < 0 {
+		return 0
+	}
+	return offset
+}
+
 type modelError string
 
 func (e modelError) Error() string {
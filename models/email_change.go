@@ -0,0 +1,13 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+// emailChange is a pending change of a user's email address,
+// applied once the new address is confirmed with the token
+type emailChange struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null; index"`
+	NewEmail  string `gorm:"not null"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+}
@@ -0,0 +1,31 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+type emailChangeDB interface {
+	GetOneByToken(token string) (*emailChange, error)
+	Create(ec *emailChange) error
+	// Deletes every pending email change of the user
+	DeleteByUserId(userID uint) error
+}
+
+type emailChangeGorm struct {
+	db *gorm.DB
+}
+
+func (ecg *emailChangeGorm) GetOneByToken(tokenHash string) (*emailChange, error) {
+	var ec emailChange
+	err := First(ecg.db.Where("token_hash = ?", tokenHash), &ec)
+	if err != nil {
+		return nil, err
+	}
+	return &ec, nil
+}
+
+func (ecg *emailChangeGorm) Create(ec *emailChange) error {
+	return ecg.db.Create(ec).Error
+}
+
+func (ecg *emailChangeGorm) DeleteByUserId(userID uint) error {
+	return ecg.db.Unscoped().Where("user_id = ?", userID).Delete(&emailChange{}).Error
+}
@@ -0,0 +1,101 @@
+package models
+
+import "go_rest_pg_starter/utils"
+
+func newEmailChangeValidator(db emailChangeDB, hmac utils.HMAC, uv *userValidator) *emailChangeValidator {
+	return &emailChangeValidator{
+		emailChangeDB: db,
+		hmac:          hmac,
+		uv:            uv,
+	}
+}
+
+type emailChangeValidator struct {
+	emailChangeDB
+	hmac utils.HMAC
+	// Used to validate the new address like any other user email
+	uv *userValidator
+}
+
+func (ecv *emailChangeValidator) requireUserID(ec *emailChange) error {
+	if ec.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (ecv *emailChangeValidator) checkNewEmail(ec *emailChange) error {
+	user := User{Email: ec.NewEmail}
+	user.ID = ec.UserID
+	err := userValidationFuncs(&user,
+		ecv.uv.normalizeEmail,
+		ecv.uv.requireEmail,
+		ecv.uv.checkEmailFormat,
+		ecv.uv.checkEmailAvailability)
+	if err != nil {
+		return err
+	}
+	ec.NewEmail = user.Email
+	return nil
+}
+
+func (ecv *emailChangeValidator) setTokenIfUnset(ec *emailChange) error {
+	if ec.Token != "" {
+		return nil
+	}
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return err
+	}
+	ec.Token = token
+	return nil
+}
+
+func (ecv *emailChangeValidator) hmacToken(ec *emailChange) error {
+	if ec.Token == "" {
+		return nil
+	}
+	ec.TokenHash = ecv.hmac.Hash(ec.Token)
+	return nil
+}
+
+type emailChangeValFunc func(*emailChange) error
+
+func runEmailChangeValFuncs(ec *emailChange, fns ...emailChangeValFunc) error {
+	for _, fn := range fns {
+		err := fn(ec)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ecv *emailChangeValidator) GetOneByToken(token string) (*emailChange, error) {
+	ec := emailChange{Token: token}
+	err := runEmailChangeValFuncs(&ec, ecv.hmacToken)
+	if err != nil {
+		return nil, err
+	}
+	return ecv.emailChangeDB.GetOneByToken(ec.TokenHash)
+}
+
+func (ecv *emailChangeValidator) Create(ec *emailChange) error {
+	err := runEmailChangeValFuncs(ec,
+		ecv.requireUserID,
+		ecv.checkNewEmail,
+		ecv.setTokenIfUnset,
+		ecv.hmacToken,
+	)
+	if err != nil {
+		return err
+	}
+	return ecv.emailChangeDB.Create(ec)
+}
+
+func (ecv *emailChangeValidator) DeleteByUserId(userID uint) error {
+	if userID <= 0 {
+		return ErrInvalidID
+	}
+	return ecv.emailChangeDB.DeleteByUserId(userID)
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Follow is an edge of the follow graph: FollowerID follows FolloweeID
+type Follow struct {
+	ID         uint `gorm:"primary_key"`
+	CreatedAt  time.Time
+	FollowerID uint `gorm:"not null; unique_index:idx_follows_follower_followee"`
+	FolloweeID uint `gorm:"not null; unique_index:idx_follows_follower_followee"`
+}
+
+// FollowService is a set of methods used to manipulate and
+// work with the follow graph between users
+type FollowService interface {
+	FollowDB
+	// WithContext returns the service for queries made on
+	// behalf of ctx, see WithTracer
+	WithContext(ctx context.Context) FollowService
+}
+
+func NewFollowService(db *gorm.DB) FollowService {
+	return &followService{
+		FollowDB: &followValidator{
+			FollowDB: &followGorm{
+				db: db,
+			},
+		},
+		db: db,
+	}
+}
+
+var _ FollowService = &followService{}
+
+type followService struct {
+	FollowDB
+	db *gorm.DB
+}
+
+func (fs *followService) WithContext(ctx context.Context) FollowService {
+	return NewFollowService(withContext(fs.db, ctx))
+}
@@ -0,0 +1,61 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type followGorm struct {
+	db *gorm.DB
+}
+
+var _ FollowDB = &followGorm{}
+
+type FollowDB interface {
+	// Users following userID, and users followed by userID,
+	// most recent follows first
+	GetFollowers(userID uint, limit, offset int) ([]User, error)
+	GetFollowing(userID uint, limit, offset int) ([]User, error)
+	// Create and Delete are idempotent
+	Create(follow *Follow) error
+	Delete(follow *Follow) error
+}
+
+func (fg *followGorm) GetFollowers(userID uint, limit, offset int) ([]User, error) {
+	var users []User
+	db := fg.db.Select("users.*").
+		Joins("JOIN follows ON follows.follower_id = users.id").
+		Where("follows.followee_id = ?", userID).
+		Order("follows.id DESC").
+		Limit(limit).Offset(offset)
+	err := db.Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (fg *followGorm) GetFollowing(userID uint, limit, offset int) ([]User, error) {
+	var users []User
+	db := fg.db.Select("users.*").
+		Joins("JOIN follows ON follows.followee_id = users.id").
+		Where("follows.follower_id = ?", userID).
+		Order("follows.id DESC").
+		Limit(limit).Offset(offset)
+	err := db.Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+	return users, nil
+}
+
+func (fg *followGorm) Create(follow *Follow) error {
+	return exec(fg.db, "INSERT INTO follows (created_at, follower_id, followee_id) VALUES (?, ?, ?) ON CONFLICT DO NOTHING",
+		time.Now(), follow.FollowerID, follow.FolloweeID).Error
+}
+
+func (fg *followGorm) Delete(follow *Follow) error {
+	return exec(fg.db, "DELETE FROM follows WHERE follower_id = ? AND followee_id = ?",
+		follow.FollowerID, follow.FolloweeID).Error
+}
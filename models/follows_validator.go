@@ -0,0 +1,63 @@
+package models
+
+type followValidator struct {
+	FollowDB
+}
+
+func (fv *followValidator) GetFollowers(userID uint, limit, offset int) ([]User, error) {
+	if userID <= 0 {
+		return nil, ErrInvalidID
+	}
+	return fv.FollowDB.GetFollowers(userID, normalizeLimit(limit), normalizeOffset(offset))
+}
+
+func (fv *followValidator) GetFollowing(userID uint, limit, offset int) ([]User, error) {
+	if userID <= 0 {
+		return nil, ErrInvalidID
+	}
+	return fv.FollowDB.GetFollowing(userID, normalizeLimit(limit), normalizeOffset(offset))
+}
+
+func (fv *followValidator) Create(follow *Follow) error {
+	err := runFollowValFuncs(follow,
+		fv.requireIDs,
+		fv.notSelf)
+	if err != nil {
+		return err
+	}
+	return fv.FollowDB.Create(follow)
+}
+
+func (fv *followValidator) Delete(follow *Follow) error {
+	err := runFollowValFuncs(follow, fv.requireIDs)
+	if err != nil {
+		return err
+	}
+	return fv.FollowDB.Delete(follow)
+}
+
+func (fv *followValidator) requireIDs(follow *Follow) error {
+	if follow.FollowerID <= 0 || follow.FolloweeID <= 0 {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+func (fv *followValidator) notSelf(follow *Follow) error {
+	if follow.FollowerID == follow.FolloweeID {
+		return ErrFollowSelf
+	}
+	return nil
+}
+
+type followValFunc func(*Follow) error
+
+func runFollowValFuncs(follow *Follow, fns ...followValFunc) error {
+	for _, fn := range fns {
+		err := fn(follow)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,64 @@
+package models
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// DBStats describes the database connection pool
+func (services *Services) DBStats() sql.DBStats {
+	return services.db.DB().Stats()
+}
+
+// Ping checks that the database can be reached
+func (services *Services) Ping(ctx context.Context) error {
+	return services.db.DB().PingContext(ctx)
+}
+
+// CheckMigrations checks that every column of the models
+// exists in the database, which is the case once AutoMigrate
+// has run with the current code
+func (services *Services) CheckMigrations(ctx context.Context) error {
+	rows, err := services.db.DB().QueryContext(ctx,
+		"SELECT table_name, column_name FROM information_schema.columns WHERE table_schema = CURRENT_SCHEMA()")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	columns := make(map[string]bool)
+	for rows.Next() {
+		var table, column string
+		err = rows.Scan(&table, &column)
+		if err != nil {
+			return err
+		}
+		columns[table+"."+column] = true
+	}
+	err = rows.Err()
+	if err != nil {
+		return err
+	}
+
+	var missing []string
+	for _, model := range migratedModels() {
+		scope := services.db.NewScope(model)
+		table := scope.TableName()
+		for _, field := range scope.GetModelStruct().StructFields {
+			if field.IsIgnored || !field.IsNormal {
+				continue
+			}
+			if !columns[table+"."+field.DBName] {
+				missing = append(missing, table+"."+field.DBName)
+			}
+		}
+	}
+	if len(missing) > 0 {
+		sort.Strings(missing)
+		return fmt.Errorf("models: migrations are not current, missing columns %s", strings.Join(missing, ", "))
+	}
+	return nil
+}
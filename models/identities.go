@@ -0,0 +1,179 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go_rest_pg_starter/utils"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Identity links a user to their account at an external
+// identity provider, so they can sign in with it
+type Identity struct {
+	ID        uint      `gorm:"primary_key" json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	UserID    uint      `gorm:"not null; unique_index:idx_identities_user_provider" json:"-"`
+	Provider  string    `gorm:"not null; unique_index:idx_identities_provider_subject; unique_index:idx_identities_user_provider" json:"provider"`
+	Subject   string    `gorm:"not null; unique_index:idx_identities_provider_subject" json:"-"`
+	Email     string    `gorm:"not null; default:''" json:"email"`
+}
+
+// ExternalIdentity is who the provider says signed in
+type ExternalIdentity struct {
+	Provider      string
+	Subject       string
+	Email         string
+	EmailVerified bool
+	// Used to pick a username for new users
+	PreferredUsername string
+	Name              string
+}
+
+// IdentityService is a set of methods used to manipulate and
+// work with identities and the users they sign in
+type IdentityService interface {
+	IdentityDB
+	// SignIn returns the user linked to the identity, creating
+	// one the first time someone signs in with it
+	SignIn(ext *ExternalIdentity) (*User, error)
+	// Link lets the user sign in with the identity from now on
+	Link(userID uint, ext *ExternalIdentity) (*Identity, error)
+	// WithContext returns the service for queries made on
+	// behalf of ctx, see WithTracer
+	WithContext(ctx context.Context) IdentityService
+}
+
+func NewIdentityService(db *gorm.DB, us UserService) IdentityService {
+	return &identityService{
+		IdentityDB: &identityValidator{
+			IdentityDB: &identityGorm{
+				db: db,
+			},
+		},
+		us: us,
+		db: db,
+	}
+}
+
+var _ IdentityService = &identityService{}
+
+type identityService struct {
+	IdentityDB
+	us UserService
+	db *gorm.DB
+}
+
+func (is *identityService) WithContext(ctx context.Context) IdentityService {
+	return NewIdentityService(withContext(is.db, ctx), is.us.WithContext(ctx))
+}
+
+func (is *identityService) SignIn(ext *ExternalIdentity) (*User, error) {
+	identity, err := is.GetByProviderSubject(ext.Provider, ext.Subject)
+	if err == nil {
+		return is.us.GetById(identity.UserID)
+	}
+	if err != ErrNotFound {
+		return nil, err
+	}
+
+	// Only a verified address can be trusted to be theirs
+	if ext.Email == "" || !ext.EmailVerified {
+		return nil, ErrIdentityUnverified
+	}
+	// Linking to an existing account must be done while signed
+	// in to it, or anyone controlling a provider account with
+	// the same address could take it over
+	_, err = is.us.GetByEmail(ext.Email)
+	if err == nil {
+		return nil, ErrIdentityEmailTaken
+	}
+	if err != ErrNotFound {
+		return nil, err
+	}
+
+	username, err := is.pickUsername(ext)
+	if err != nil {
+		return nil, err
+	}
+	user := User{
+		Username:      username,
+		Email:         ext.Email,
+		DisplayName:   ext.Name,
+		ExternalLogin: true,
+	}
+	// Too long for a display name, let them pick one
+	if utf8.RuneCountInString(user.DisplayName) > 50 {
+		user.DisplayName = ""
+	}
+	err = is.us.Create(&user)
+	if err != nil {
+		return nil, err
+	}
+
+	_, err = is.Link(user.ID, ext)
+	if err != nil {
+		// Do not leave a user nobody can sign in as
+		is.us.Delete(user.ID)
+		return nil, err
+	}
+	return &user, nil
+}
+
+func (is *identityService) Link(userID uint, ext *ExternalIdentity) (*Identity, error) {
+	identity := Identity{
+		UserID:   userID,
+		Provider: ext.Provider,
+		Subject:  ext.Subject,
+		Email:    ext.Email,
+	}
+	err := is.Create(&identity)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+var usernameInvalidChars = regexp.MustCompile(`[^a-z0-9_]+`)
+
+// Derives a free username from what the provider knows of the
+// user, adding random digits when it is taken
+func (is *identityService) pickUsername(ext *ExternalIdentity) (string, error) {
+	base := ext.PreferredUsername
+	if base == "" {
+		base = strings.SplitN(ext.Email, "@", 2)[0]
+	}
+	base = usernameInvalidChars.ReplaceAllString(strings.ToLower(base), "_")
+	base = strings.Trim(base, "_")
+	if base == "" || base[0] < 'a' || base[0] > 'z' {
+		base = "user_" + base
+	}
+	if len(base) > 24 {
+		base = base[:24]
+	}
+	for len(base) < 3 {
+		base += "_"
+	}
+
+	candidate := base
+	for i := 0; i < 5; i++ {
+		_, err := is.us.GetByUsername(candidate)
+		if err == ErrNotFound {
+			return candidate, nil
+		}
+		if err != nil {
+			return "", err
+		}
+		b, err := utils.GenerateRandomBytes(2)
+		if err != nil {
+			return "", err
+		}
+		candidate = fmt.Sprintf("%s_%04d", base, (int(b[0])<<8|int(b[1]))%10000)
+	}
+	return "", ErrUsernameTaken
+}
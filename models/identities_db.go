@@ -0,0 +1,50 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+type identityGorm struct {
+	db *gorm.DB
+}
+
+var _ IdentityDB = &identityGorm{}
+
+type IdentityDB interface {
+	GetByProviderSubject(provider, subject string) (*Identity, error)
+	GetAllByUserId(userID uint) ([]Identity, error)
+	Create(identity *Identity) error
+	// Deletes the identity of the user at provider
+	Delete(userID uint, provider string) error
+}
+
+func (ig *identityGorm) GetByProviderSubject(provider, subject string) (*Identity, error) {
+	var identity Identity
+	err := First(ig.db.Where("provider = ? AND subject = ?", provider, subject), &identity)
+	if err != nil {
+		return nil, err
+	}
+	return &identity, nil
+}
+
+func (ig *identityGorm) GetAllByUserId(userID uint) ([]Identity, error) {
+	var identities []Identity
+	err := ig.db.Where("user_id = ?", userID).Order("id").Find(&identities).Error
+	if err != nil {
+		return nil, err
+	}
+	return identities, nil
+}
+
+func (ig *identityGorm) Create(identity *Identity) error {
+	return ig.db.Create(identity).Error
+}
+
+func (ig *identityGorm) Delete(userID uint, provider string) error {
+	res := ig.db.Where("user_id = ? AND provider = ?", userID, provider).Delete(&Identity{})
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package models
+
+type identityValidator struct {
+	IdentityDB
+}
+
+func (iv *identityValidator) GetAllByUserId(userID uint) ([]Identity, error) {
+	if userID <= 0 {
+		return nil, ErrInvalidID
+	}
+	return iv.IdentityDB.GetAllByUserId(userID)
+}
+
+func (iv *identityValidator) Create(identity *Identity) error {
+	err := runIdentityValFuncs(identity,
+		iv.requireFields,
+		iv.checkAvailability)
+	if err != nil {
+		return err
+	}
+	return iv.IdentityDB.Create(identity)
+}
+
+func (iv *identityValidator) Delete(userID uint, provider string) error {
+	if userID <= 0 {
+		return ErrInvalidID
+	}
+	return iv.IdentityDB.Delete(userID, provider)
+}
+
+func (iv *identityValidator) requireFields(identity *Identity) error {
+	if identity.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	if identity.Provider == "" || identity.Subject == "" {
+		return ErrIdentityInvalid
+	}
+	return nil
+}
+
+// An identity signs in a single user, and a user has at
+// most one identity per provider
+func (iv *identityValidator) checkAvailability(identity *Identity) error {
+	existing, err := iv.IdentityDB.GetByProviderSubject(identity.Provider, identity.Subject)
+	if err == nil {
+		if existing.UserID == identity.UserID {
+			return ErrIdentityAlreadyLinked
+		}
+		return ErrIdentityTaken
+	}
+	if err != ErrNotFound {
+		return err
+	}
+
+	identities, err := iv.IdentityDB.GetAllByUserId(identity.UserID)
+	if err != nil {
+		return err
+	}
+	for _, linked := range identities {
+		if linked.Provider == identity.Provider {
+			return ErrIdentityAlreadyLinked
+		}
+	}
+	return nil
+}
+
+type identityValFunc func(*Identity) error
+
+func runIdentityValFuncs(identity *Identity, fns ...identityValFunc) error {
+	for _, fn := range fns {
+		err := fn(identity)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
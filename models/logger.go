@@ -0,0 +1,41 @@
+package models
+
+import (
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+// gormLogger writes the logs of GORM to a structured logger.
+// The values bound to queries are left out, since they can be
+// password hashes or tokens.
+type gormLogger struct {
+	logger *slog.Logger
+}
+
+func (gl gormLogger) Print(values ...interface{}) {
+	if len(values) < 2 {
+		gl.logger.Debug(fmt.Sprint(values...))
+		return
+	}
+
+	source := fmt.Sprint(values[1])
+	if values[0] == "sql" && len(values) >= 6 {
+		duration, _ := values[2].(time.Duration)
+		gl.logger.Debug("sql",
+			"query", values[3],
+			"duration_ms", float64(duration.Microseconds())/1000,
+			"rows", values[5],
+			"source", source)
+		return
+	}
+	gl.logger.Error(fmt.Sprint(values[2:]...), "source", source)
+}
+
+// Where models log to if no logger is set
+func defaultLogger(logger *slog.Logger) *slog.Logger {
+	if logger == nil {
+		return slog.Default()
+	}
+	return logger
+}
@@ -0,0 +1,12 @@
+package models
+
+import "github.com/jinzhu/gorm"
+
+// magicLink lets a user sign in once with a token emailed
+// to them instead of their password
+type magicLink struct {
+	gorm.Model
+	UserID    uint   `gorm:"not null; index"`
+	Token     string `gorm:"-"`
+	TokenHash string `gorm:"not null;unique_index"`
+}
@@ -0,0 +1,47 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type magicLinkDB interface {
+	// Consume deletes the magic link matching the token and
+	// returns it, so that a token can only ever be used once
+	Consume(token string) (*magicLink, error)
+	Create(ml *magicLink) error
+	// Deletes every magic link issued for the user
+	DeleteByUserId(userID uint) error
+	DeleteCreatedBefore(before time.Time) (int64, error)
+}
+
+type magicLinkGorm struct {
+	db *gorm.DB
+}
+
+func (mlg *magicLinkGorm) Consume(tokenHash string) (*magicLink, error) {
+	var ml magicLink
+	err := mlg.db.Raw("DELETE FROM magic_links WHERE token_hash = ? AND deleted_at IS NULL RETURNING *",
+		tokenHash).Scan(&ml).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &ml, nil
+}
+
+func (mlg *magicLinkGorm) Create(ml *magicLink) error {
+	return mlg.db.Create(ml).Error
+}
+
+func (mlg *magicLinkGorm) DeleteByUserId(userID uint) error {
+	return mlg.db.Unscoped().Where("user_id = ?", userID).Delete(&magicLink{}).Error
+}
+
+func (mlg *magicLinkGorm) DeleteCreatedBefore(before time.Time) (int64, error) {
+	res := mlg.db.Unscoped().Where("created_at < ?", before).Delete(&magicLink{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,87 @@
+package models
+
+import "go_rest_pg_starter/utils"
+
+func newMagicLinkValidator(db magicLinkDB, hmac utils.HMAC) *magicLinkValidator {
+	return &magicLinkValidator{
+		magicLinkDB: db,
+		hmac:        hmac,
+	}
+}
+
+type magicLinkValidator struct {
+	magicLinkDB
+	hmac utils.HMAC
+}
+
+func (mlv *magicLinkValidator) requireUserID(ml *magicLink) error {
+	if ml.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (mlv *magicLinkValidator) setTokenIfUnset(ml *magicLink) error {
+	if ml.Token != "" {
+		return nil
+	}
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return err
+	}
+	ml.Token = token
+	return nil
+}
+
+func (mlv *magicLinkValidator) hmacToken(ml *magicLink) error {
+	if ml.Token == "" {
+		return nil
+	}
+	ml.TokenHash = mlv.hmac.Hash(ml.Token)
+	return nil
+}
+
+type magicLinkValFunc func(*magicLink) error
+
+func runMagicLinkValFuncs(ml *magicLink, fns ...magicLinkValFunc) error {
+	for _, fn := range fns {
+		err := fn(ml)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (mlv *magicLinkValidator) Consume(token string) (*magicLink, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	ml := magicLink{Token: token}
+	err := runMagicLinkValFuncs(&ml, mlv.hmacToken)
+	if err != nil {
+		return nil, err
+	}
+	return mlv.magicLinkDB.Consume(ml.TokenHash)
+}
+
+func (mlv *magicLinkValidator) Create(ml *magicLink) error {
+	err := runMagicLinkValFuncs(ml,
+		mlv.requireUserID,
+		mlv.setTokenIfUnset,
+		mlv.hmacToken,
+	)
+	if err != nil {
+		return err
+	}
+	return mlv.magicLinkDB.Create(ml)
+}
+
+func (mlv *magicLinkValidator) DeleteByUserId(userID uint) error {
+	ml := magicLink{UserID: userID}
+	err := runMagicLinkValFuncs(&ml, mlv.requireUserID)
+	if err != nil {
+		return err
+	}
+	return mlv.magicLinkDB.DeleteByUserId(userID)
+}
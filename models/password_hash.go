@@ -0,0 +1,195 @@
+package models
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Password hashing algorithms
+const (
+	HashArgon2id = "argon2id"
+	HashBcrypt   = "bcrypt"
+)
+
+// Hashes stored before hashes were versioned are plain bcrypt
+// hashes peppered with the pepper of this id
+const legacyPepperID = "1"
+
+// PasswordHashConfig sets how new password hashes are made.
+// Stored hashes made any other way still verify, and are
+// replaced the next time their user logs in.
+type PasswordHashConfig struct {
+	Algorithm  string
+	BcryptCost int
+	// argon2id parameters, memory is in KiB
+	Argon2Time    uint32
+	Argon2Memory  uint32
+	Argon2Threads uint8
+	// Id of the current pepper, recorded in every hash so the
+	// pepper can be rotated
+	PepperID string
+	// Previous peppers by id, needed to verify hashes that
+	// have not been upgraded yet
+	OldPeppers map[string]string
+}
+
+func DefaultPasswordHashConfig() PasswordHashConfig {
+	return PasswordHashConfig{
+		Algorithm:     HashArgon2id,
+		BcryptCost:    bcrypt.DefaultCost,
+		Argon2Time:    2,
+		Argon2Memory:  19 * 1024,
+		Argon2Threads: 1,
+		PepperID:      legacyPepperID,
+	}
+}
+
+const (
+	argon2SaltLen = 16
+	argon2KeyLen  = 32
+)
+
+type passwordHasher struct {
+	cfg     PasswordHashConfig
+	peppers map[string]string
+}
+
+func newPasswordHasher(cfg PasswordHashConfig, pepper string) *passwordHasher {
+	peppers := make(map[string]string, len(cfg.OldPeppers)+1)
+	for id, p := range cfg.OldPeppers {
+		peppers[id] = p
+	}
+	peppers[cfg.PepperID] = pepper
+	return &passwordHasher{
+		cfg:     cfg,
+		peppers: peppers,
+	}
+}
+
+// Hash hashes password with the configured algorithm and the
+// current pepper
+func (h *passwordHasher) Hash(password string) (string, error) {
+	pepper := h.peppers[h.cfg.PepperID]
+
+	switch h.cfg.Algorithm {
+	case HashBcrypt:
+		hashedBytes, err := bcrypt.GenerateFromPassword([]byte(password+pepper), h.cfg.BcryptCost)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("$bcrypt$k=%s%s", h.cfg.PepperID, hashedBytes), nil
+	case HashArgon2id:
+		salt := make([]byte, argon2SaltLen)
+		_, err := rand.Read(salt)
+		if err != nil {
+			return "", err
+		}
+		key := argon2.IDKey([]byte(password+pepper), salt,
+			h.cfg.Argon2Time, h.cfg.Argon2Memory, h.cfg.Argon2Threads, argon2KeyLen)
+		return fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d,k=%s$%s$%s",
+			argon2.Version, h.cfg.Argon2Memory, h.cfg.Argon2Time, h.cfg.Argon2Threads, h.cfg.PepperID,
+			base64.RawStdEncoding.EncodeToString(salt),
+			base64.RawStdEncoding.EncodeToString(key)), nil
+	default:
+		return "", fmt.Errorf("models: unknown password hash algorithm %q", h.cfg.Algorithm)
+	}
+}
+
+// Verify checks password against a stored hash. When it matches,
+// needsRehash tells whether the hash was made with outdated
+// parameters or an old pepper and should be replaced.
+func (h *passwordHasher) Verify(password, hash string) (ok, needsRehash bool, err error) {
+	switch {
+	case strings.HasPrefix(hash, "$argon2id$"):
+		return h.verifyArgon2id(password, hash)
+	case strings.HasPrefix(hash, "$bcrypt$"):
+		// $bcrypt$k=<pepper id>$2a$...
+		rest := strings.TrimPrefix(hash, "$bcrypt$k=")
+		i := strings.Index(rest, "$")
+		if i < 0 {
+			return false, false, ErrPasswordHashInvalid
+		}
+		return h.verifyBcrypt(password, rest[i:], rest[:i])
+	default:
+		ok, _, err := h.verifyBcrypt(password, hash, legacyPepperID)
+		return ok, ok, err
+	}
+}
+
+func (h *passwordHasher) verifyBcrypt(password, hash, pepperID string) (bool, bool, error) {
+	pepper, found := h.peppers[pepperID]
+	if !found {
+		return false, false, ErrPasswordHashInvalid
+	}
+
+	err := bcrypt.CompareHashAndPassword([]byte(hash), []byte(password+pepper))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return false, false, nil
+	}
+	if err != nil {
+		return false, false, err
+	}
+
+	cost, err := bcrypt.Cost([]byte(hash))
+	if err != nil {
+		return false, false, err
+	}
+	needsRehash := h.cfg.Algorithm != HashBcrypt || cost != h.cfg.BcryptCost || pepperID != h.cfg.PepperID
+	return true, needsRehash, nil
+}
+
+func (h *passwordHasher) verifyArgon2id(password, hash string) (bool, bool, error) {
+	// "", "argon2id", "v=19", "m=..,t=..,p=..,k=..", salt, key
+	parts := strings.Split(hash, "$")
+	if len(parts) != 6 {
+		return false, false, ErrPasswordHashInvalid
+	}
+
+	var version int
+	_, err := fmt.Sscanf(parts[2], "v=%d", &version)
+	if err != nil || version != argon2.Version {
+		return false, false, ErrPasswordHashInvalid
+	}
+
+	var memory, time uint32
+	var threads uint8
+	var pepperID string
+	params := strings.SplitN(parts[3], ",k=", 2)
+	if len(params) != 2 {
+		return false, false, ErrPasswordHashInvalid
+	}
+	_, err = fmt.Sscanf(params[0], "m=%d,t=%d,p=%d", &memory, &time, &threads)
+	if err != nil {
+		return false, false, ErrPasswordHashInvalid
+	}
+	pepperID = params[1]
+	pepper, found := h.peppers[pepperID]
+	if !found {
+		return false, false, ErrPasswordHashInvalid
+	}
+
+	salt, err := base64.RawStdEncoding.DecodeString(parts[4])
+	if err != nil {
+		return false, false, ErrPasswordHashInvalid
+	}
+	key, err := base64.RawStdEncoding.DecodeString(parts[5])
+	if err != nil {
+		return false, false, ErrPasswordHashInvalid
+	}
+
+	computed := argon2.IDKey([]byte(password+pepper), salt, time, memory, threads, uint32(len(key)))
+	if subtle.ConstantTimeCompare(computed, key) != 1 {
+		return false, false, nil
+	}
+
+	needsRehash := h.cfg.Algorithm != HashArgon2id ||
+		memory != h.cfg.Argon2Memory || time != h.cfg.Argon2Time || threads != h.cfg.Argon2Threads ||
+		pepperID != h.cfg.PepperID
+	return true, needsRehash, nil
+}
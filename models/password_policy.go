@@ -0,0 +1,132 @@
+package models
+
+import (
+	_ "embed"
+	"fmt"
+	"log/slog"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// BreachedPasswordChecker reports whether a password appeared
+// in known data breaches, see the pwned package
+type BreachedPasswordChecker interface {
+	IsBreached(password string) (bool, error)
+}
+
+// PasswordPolicy sets the rules new passwords must follow.
+// Lengths are counted in characters (runes), not bytes.
+type PasswordPolicy struct {
+	MinLength int
+	// 0 means no maximum
+	MaxLength int
+	// How many of lowercase letters, uppercase letters, digits
+	// and symbols the password must mix
+	MinCharClasses int
+	// Reject passwords containing the username or the local
+	// part of the email
+	RejectPersonalInfo bool
+	// Reject passwords from the bundled common passwords list
+	RejectCommon bool
+	// Optional. When the check itself fails the password is
+	// accepted, so an outage does not block signups.
+	BreachedChecker BreachedPasswordChecker
+
+	logger *slog.Logger
+}
+
+func DefaultPasswordPolicy() PasswordPolicy {
+	return PasswordPolicy{
+		MinLength:          8,
+		MaxLength:          64,
+		MinCharClasses:     1,
+		RejectPersonalInfo: true,
+		RejectCommon:       true,
+	}
+}
+
+//go:embed common_passwords.txt
+var commonPasswordsFile string
+
+var commonPasswords = parseCommonPasswords(commonPasswordsFile)
+
+func parseCommonPasswords(file string) map[string]bool {
+	passwords := make(map[string]bool)
+	for _, line := range strings.Split(file, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		passwords[strings.ToLower(line)] = true
+	}
+	return passwords
+}
+
+// Check returns the first rule the password of user breaks.
+// user.Password is the new, plain text password.
+func (p PasswordPolicy) Check(user *User) error {
+	password := user.Password
+	length := utf8.RuneCountInString(password)
+	if length < p.MinLength {
+		return modelError(fmt.Sprintf("models: Password must be at least %d characters long", p.MinLength))
+	}
+	if p.MaxLength > 0 && length > p.MaxLength {
+		return modelError(fmt.Sprintf("models: Password must be at most %d characters long", p.MaxLength))
+	}
+
+	if countCharClasses(password) < p.MinCharClasses {
+		return modelError(fmt.Sprintf("models: Password must mix at least %d of lowercase letters, uppercase letters, digits and symbols", p.MinCharClasses))
+	}
+
+	lowered := strings.ToLower(password)
+	if p.RejectPersonalInfo && containsPersonalInfo(lowered, user) {
+		return ErrPasswordPersonal
+	}
+	if p.RejectCommon && commonPasswords[lowered] {
+		return ErrPasswordCommon
+	}
+
+	if p.BreachedChecker != nil {
+		breached, err := p.BreachedChecker.IsBreached(password)
+		if err != nil {
+			defaultLogger(p.logger).Error("could not check for a breached password", "error", err)
+			return nil
+		}
+		if breached {
+			return ErrPasswordBreached
+		}
+	}
+	return nil
+}
+
+func countCharClasses(password string) int {
+	var lower, upper, digit, symbol int
+	for _, r := range password {
+		switch {
+		case unicode.IsLower(r):
+			lower = 1
+		case unicode.IsUpper(r):
+			upper = 1
+		case unicode.IsDigit(r):
+			digit = 1
+		default:
+			symbol = 1
+		}
+	}
+	return lower + upper + digit + symbol
+}
+
+// Parts shorter than 3 characters would match too much
+func containsPersonalInfo(lowered string, user *User) bool {
+	parts := []string{strings.ToLower(user.Username)}
+	if at := strings.LastIndex(user.Email, "@"); at >= 0 {
+		parts = append(parts, strings.ToLower(user.Email[:at]))
+	}
+	for _, part := range parts {
+		if utf8.RuneCountInString(part) >= 3 && strings.Contains(lowered, part) {
+			return true
+		}
+	}
+	return false
+}
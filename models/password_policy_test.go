@@ -0,0 +1,148 @@
+package models_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"go_rest_pg_starter/models"
+	"go_rest_pg_starter/pwned"
+)
+
+type failingChecker struct{}
+
+func (failingChecker) IsBreached(string) (bool, error) {
+	return false, errors.New("unavailable")
+}
+
+func TestPasswordPolicyLength(t *testing.T) {
+	policy := models.PasswordPolicy{MinLength: 8, MaxLength: 10}
+
+	tests := []struct {
+		name     string
+		password string
+		wantErr  bool
+	}{
+		{"too short", "abcdefg", true},
+		{"shortest", "abcdefgh", false},
+		{"longest", "abcdefghij", false},
+		{"too long", "abcdefghijk", true},
+		// 8 runes but 16 bytes
+		{"multibyte shortest", strings.Repeat("é", 8), false},
+		{"multibyte too short", strings.Repeat("é", 7), true},
+		// 10 runes but 30 bytes
+		{"multibyte longest", strings.Repeat("日", 10), false},
+		{"multibyte too long", strings.Repeat("日", 11), true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := policy.Check(&models.User{Password: tt.password})
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Check(%q) error = %v, want error %v", tt.password, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestPasswordPolicyNoMaxLength(t *testing.T) {
+	policy := models.PasswordPolicy{MinLength: 8}
+
+	err := policy.Check(&models.User{Password: strings.Repeat("a", 1000)})
+	if err != nil {
+		t.Errorf("Check() error = %v, want nil without a maximum", err)
+	}
+}
+
+func TestPasswordPolicyCharClasses(t *testing.T) {
+	policy := models.PasswordPolicy{MinCharClasses: 3}
+
+	tests := []struct {
+		password string
+		wantErr  bool
+	}{
+		{"abcdefgh", true},
+		{"abcdEFGH", true},
+		{"abcdEFG1", false},
+		{"abcdefg1!", false},
+		{"ABCD1234 ", false},
+		// Non-ASCII letters count as letters, not symbols
+		{"ÀÉÎÕüéîõ", true},
+		{"ÀÉÎÕüéî5", false},
+	}
+	for _, tt := range tests {
+		err := policy.Check(&models.User{Password: tt.password})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("Check(%q) error = %v, want error %v", tt.password, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPasswordPolicyPersonalInfo(t *testing.T) {
+	policy := models.PasswordPolicy{RejectPersonalInfo: true}
+
+	tests := []struct {
+		name     string
+		username string
+		email    string
+		password string
+		want     error
+	}{
+		{"username", "alice", "a@example.com", "xxAliceyy", models.ErrPasswordPersonal},
+		{"email local part", "bob", "wonderland@example.com", "my-WONDERLAND-pass", models.ErrPasswordPersonal},
+		{"email domain", "bob", "b@wonderland.com", "my-wonderland-pass", nil},
+		{"short username", "al", "a@example.com", "alalalal", nil},
+		{"unrelated", "alice", "alice@example.com", "correct horse", nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			user := &models.User{Username: tt.username, Email: tt.email, Password: tt.password}
+			err := policy.Check(user)
+			if err != tt.want {
+				t.Errorf("Check() error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+
+	policy.RejectPersonalInfo = false
+	err := policy.Check(&models.User{Username: "alice", Password: "alice123"})
+	if err != nil {
+		t.Errorf("Check() error = %v, want nil when not rejecting personal info", err)
+	}
+}
+
+func TestPasswordPolicyCommon(t *testing.T) {
+	policy := models.PasswordPolicy{RejectCommon: true}
+
+	for _, password := range []string{"password", "PassWord", "qwerty123"} {
+		err := policy.Check(&models.User{Password: password})
+		if err != models.ErrPasswordCommon {
+			t.Errorf("Check(%q) error = %v, want %v", password, err, models.ErrPasswordCommon)
+		}
+	}
+	err := policy.Check(&models.User{Password: "uncommon-passphrase"})
+	if err != nil {
+		t.Errorf("Check() error = %v, want nil", err)
+	}
+}
+
+func TestPasswordPolicyBreached(t *testing.T) {
+	policy := models.PasswordPolicy{
+		BreachedChecker: pwned.NewChecker(pwned.NewFakeSource("breached-passphrase")),
+	}
+
+	err := policy.Check(&models.User{Password: "breached-passphrase"})
+	if err != models.ErrPasswordBreached {
+		t.Errorf("Check() error = %v, want %v", err, models.ErrPasswordBreached)
+	}
+	err = policy.Check(&models.User{Password: "other-passphrase"})
+	if err != nil {
+		t.Errorf("Check() error = %v, want nil", err)
+	}
+
+	// An outage of the checker does not block signups
+	policy.BreachedChecker = failingChecker{}
+	err = policy.Check(&models.User{Password: "breached-passphrase"})
+	if err != nil {
+		t.Errorf("Check() with a failing checker error = %v, want nil", err)
+	}
+}
@@ -1,11 +1,20 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
 
 type passwordResetDB interface {
 	GetOneByToken(token string) (*passwordReset, error)
+	// Consume deletes the reset matching the token and returns
+	// it, so that a token can only ever be used once
+	Consume(token string) (*passwordReset, error)
 	Create(pwr *passwordReset) error
-	Delete(id uint) error
+	// Deletes every reset issued for the user
+	DeleteByUserId(userID uint) error
+	DeleteCreatedBefore(before time.Time) (int64, error)
 }
 
 type passwordResetGorm struct {
@@ -21,13 +30,30 @@ func (pwrg *passwordResetGorm) GetOneByToken(tokenHash string) (*passwordReset,
 	return &pwr, nil
 }
 
+func (pwrg *passwordResetGorm) Consume(tokenHash string) (*passwordReset, error) {
+	var pwr passwordReset
+	// Deleting and reading in one statement keeps two concurrent
+	// requests from both using the token
+	err := pwrg.db.Raw("DELETE FROM password_resets WHERE token_hash = ? AND deleted_at IS NULL RETURNING *",
+		tokenHash).Scan(&pwr).Error
+	if err == gorm.ErrRecordNotFound {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &pwr, nil
+}
+
 func (pwrg *passwordResetGorm) Create(pwr *passwordReset) error {
 	return pwrg.db.Create(pwr).Error
 }
 
-func (pwrg *passwordResetGorm) Delete(id uint) error {
-	pwr := passwordReset{
-		Model: gorm.Model{ID: id},
-	}
-	return pwrg.db.Delete(&pwr).Error
+func (pwrg *passwordResetGorm) DeleteByUserId(userID uint) error {
+	return pwrg.db.Unscoped().Where("user_id = ?", userID).Delete(&passwordReset{}).Error
+}
+
+func (pwrg *passwordResetGorm) DeleteCreatedBefore(before time.Time) (int64, error) {
+	res := pwrg.db.Unscoped().Where("created_at < ?", before).Delete(&passwordReset{})
+	return res.RowsAffected, res.Error
 }
@@ -54,6 +54,9 @@ func runPwResetValFuncs(pwr *passwordReset, fns ...pwResetValFunc) error {
 }
 
 func (pwrv *passwordResetValidator) GetOneByToken(token string) (*passwordReset, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
 	pwr := passwordReset{Token: token}
 	err := runPwResetValFuncs(&pwr, pwrv.hmacToken)
 	if err != nil {
@@ -62,6 +65,18 @@ func (pwrv *passwordResetValidator) GetOneByToken(token string) (*passwordReset,
 	return pwrv.passwordResetDB.GetOneByToken(pwr.TokenHash)
 }
 
+func (pwrv *passwordResetValidator) Consume(token string) (*passwordReset, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	pwr := passwordReset{Token: token}
+	err := runPwResetValFuncs(&pwr, pwrv.hmacToken)
+	if err != nil {
+		return nil, err
+	}
+	return pwrv.passwordResetDB.Consume(pwr.TokenHash)
+}
+
 func (pwrv *passwordResetValidator) Create(pwr *passwordReset) error {
 	err := runPwResetValFuncs(pwr,
 		pwrv.requireUserID,
@@ -74,9 +89,11 @@ func (pwrv *passwordResetValidator) Create(pwr *passwordReset) error {
 	return pwrv.passwordResetDB.Create(pwr)
 }
 
-func (pwrv *passwordResetValidator) Delete(id uint) error {
-	if id <= 0 {
-		return ErrInvalidID
+func (pwrv *passwordResetValidator) DeleteByUserId(userID uint) error {
+	pwr := passwordReset{UserID: userID}
+	err := runPwResetValFuncs(&pwr, pwrv.requireUserID)
+	if err != nil {
+		return err
 	}
-	return pwrv.passwordResetDB.Delete(id)
+	return pwrv.passwordResetDB.DeleteByUserId(userID)
 }
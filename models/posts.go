@@ -1,20 +1,72 @@
 package models
 
 import (
+	"context"
+	"time"
+
 	"github.com/jinzhu/gorm"
 )
 
+// Post statuses. Only published posts are visible to everyone,
+// the others are only visible to their author.
+const (
+	PostDraft     = "draft"
+	PostScheduled = "scheduled"
+	PostPublished = "published"
+	PostArchived  = "archived"
+)
+
 type Post struct {
 	gorm.Model
-	Title       string `gorm:"not null"`
-	Description string `gorm:"not null"`
-	UserID      uint   `gorm:"not null; index"`
+	Title       string     `gorm:"not null"`
+	Description string     `gorm:"not null"`
+	UserID      uint       `gorm:"not null; index"`
+	Status      string     `gorm:"not null; default:'published'; index"`
+	PublishAt   *time.Time `gorm:"index"`
+	// Reaction counters, see ReactionCounts
+	LikeCount  int `gorm:"not null; default:0" json:"-"`
+	LoveCount  int `gorm:"not null; default:0" json:"-"`
+	LaughCount int `gorm:"not null; default:0" json:"-"`
+	WowCount   int `gorm:"not null; default:0" json:"-"`
+}
+
+// ReactionCounts returns how many reactions of each kind the post has
+func (post *Post) ReactionCounts() map[string]int {
+	return map[string]int{
+		ReactionLike:  post.LikeCount,
+		ReactionLove:  post.LoveCount,
+		ReactionLaugh: post.LaughCount,
+		ReactionWow:   post.WowCount,
+	}
+}
+
+// FeedCursor points at the last post of a feed page. The next
+// page starts right after it.
+type FeedCursor struct {
+	PublishAt time.Time
+	ID        uint
+}
+
+// IsVisibleTo reports whether the post can be seen by the user
+// with the given id. Pass 0 for anonymous visitors.
+func (post *Post) IsVisibleTo(userID uint) bool {
+	if post.Status == PostPublished {
+		return true
+	}
+	return userID != 0 && post.UserID == userID
 }
 
 // PostService is a set of methods used to manipulate and
 // work with the Post model
 type PostService interface {
 	PostDB
+	// WithContext returns the service for queries made on
+	// behalf of ctx, see WithTracer
+	WithContext(ctx context.Context) PostService
+	React(postID, userID uint, kind string) error
+	Unreact(postID, userID uint, kind string) error
+	// Kinds of reactions the user left on the post
+	GetReactionKinds(postID, userID uint) ([]string, error)
 }
 
 func NewPostService(db *gorm.DB) PostService {
@@ -24,6 +76,12 @@ func NewPostService(db *gorm.DB) PostService {
 				db: db,
 			},
 		},
+		reactionDB: &reactionValidator{
+			reactionDB: &reactionGorm{
+				db: db,
+			},
+		},
+		db: db,
 	}
 }
 
@@ -31,4 +89,30 @@ var _ PostService = &postService{}
 
 type postService struct {
 	PostDB
+	reactionDB reactionDB
+	db         *gorm.DB
+}
+
+func (ps *postService) WithContext(ctx context.Context) PostService {
+	return NewPostService(withContext(ps.db, ctx))
+}
+
+func (ps *postService) React(postID, userID uint, kind string) error {
+	return ps.reactionDB.Create(&Reaction{
+		PostID: postID,
+		UserID: userID,
+		Kind:   kind,
+	})
+}
+
+func (ps *postService) Unreact(postID, userID uint, kind string) error {
+	return ps.reactionDB.Delete(&Reaction{
+		PostID: postID,
+		UserID: userID,
+		Kind:   kind,
+	})
+}
+
+func (ps *postService) GetReactionKinds(postID, userID uint) ([]string, error) {
+	return ps.reactionDB.GetKindsByUser(postID, userID)
 }
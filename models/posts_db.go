@@ -1,6 +1,10 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
 
 type postGorm struct {
 	db *gorm.DB
@@ -11,9 +15,21 @@ var _ PostDB = &postGorm{}
 type PostDB interface {
 	GetOneById(id uint) (*Post, error)
 	GetAllByUserId(userId uint) ([]Post, error)
+	// A page of the user's posts, newest first
+	GetPageByUserId(userId uint, publishedOnly bool, limit, offset int) ([]Post, error)
+	// Published posts of the users followed by userId, newest first,
+	// starting after the cursor when one is given
+	GetFeed(userId uint, after *FeedCursor, limit int) ([]Post, error)
+	// Soft-deleted posts (trash)
+	GetDeletedById(id uint) (*Post, error)
+	GetDeletedByUserId(userId uint) ([]Post, error)
 	Create(post *Post) error
 	Update(post *Post) error
 	Delete(id uint) error
+	Restore(id uint) error
+	// Publishes every scheduled post that is due at the given time
+	// and returns how many posts were published
+	PublishDue(now time.Time) (int64, error)
 }
 
 func (pg *postGorm) GetOneById(id uint) (*Post, error) {
@@ -36,15 +52,91 @@ func (pg *postGorm) GetAllByUserId(userId uint) ([]Post, error) {
 	return posts, nil
 }
 
+func (pg *postGorm) GetPageByUserId(userId uint, publishedOnly bool, limit, offset int) ([]Post, error) {
+	var posts []Post
+	db := pg.db.Where("user_id = ?", userId)
+	if publishedOnly {
+		db = db.Where("status = ?", PostPublished)
+	}
+	err := db.Order("publish_at DESC NULLS FIRST, id DESC").
+		Limit(limit).Offset(offset).
+		Find(&posts).Error
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func (pg *postGorm) GetFeed(userId uint, after *FeedCursor, limit int) ([]Post, error) {
+	var posts []Post
+	db := pg.db.Select("posts.*").
+		Joins("JOIN follows ON follows.followee_id = posts.user_id").
+		Where("follows.follower_id = ? AND posts.status = ?", userId, PostPublished)
+	if after != nil {
+		db = db.Where("(posts.publish_at, posts.id) < (?, ?)", after.PublishAt, after.ID)
+	}
+	err := db.Order("posts.publish_at DESC, posts.id DESC").
+		Limit(limit).
+		Find(&posts).Error
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
+func (pg *postGorm) GetDeletedById(id uint) (*Post, error) {
+	var post Post
+	db := pg.db.Unscoped().Where("id = ? AND deleted_at IS NOT NULL", id)
+	err := First(db, &post)
+	if err != nil {
+		return nil, err
+	}
+	return &post, nil
+}
+
+func (pg *postGorm) GetDeletedByUserId(userId uint) ([]Post, error) {
+	var posts []Post
+	db := pg.db.Unscoped().
+		Where("user_id = ? AND deleted_at IS NOT NULL", userId).
+		Order("deleted_at DESC")
+	err := db.Find(&posts).Error
+	if err != nil {
+		return nil, err
+	}
+	return posts, nil
+}
+
 func (pg *postGorm) Create(post *Post) error {
 	return pg.db.Create(post).Error
 }
 
+// Reaction counters are left alone, they are only
+// changed together with the reactions themselves
 func (pg *postGorm) Update(post *Post) error {
-	return pg.db.Save(post).Error
+	return pg.db.Omit("like_count", "love_count", "laugh_count", "wow_count").Save(post).Error
 }
 
 func (pg *postGorm) Delete(id uint) error {
 	post := &Post{Model: gorm.Model{ID: id}}
 	return pg.db.Delete(post).Error
 }
+
+func (pg *postGorm) Restore(id uint) error {
+	db := pg.db.Unscoped().Model(&Post{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (pg *postGorm) PublishDue(now time.Time) (int64, error) {
+	db := pg.db.Model(&Post{}).
+		Where("status = ? AND publish_at <= ?", PostScheduled, now).
+		Updates(map[string]interface{}{"status": PostPublished})
+	return db.RowsAffected, db.Error
+}
@@ -1,5 +1,10 @@
 package models
 
+import (
+	"strings"
+	"time"
+)
+
 type postValidator struct {
 	PostDB
 }
@@ -8,7 +13,10 @@ func (pv *postValidator) Create(post *Post) error {
 	err := postValidationFuncs(post,
 		pv.userIDRequired,
 		pv.titleRequired,
-		pv.descRequired)
+		pv.descRequired,
+		pv.normalizeStatus,
+		pv.checkStatus,
+		pv.setPublishAt)
 
 	if err != nil {
 		return err
@@ -21,7 +29,10 @@ func (pv *postValidator) Update(post *Post) error {
 	err := postValidationFuncs(post,
 		pv.userIDRequired,
 		pv.titleRequired,
-		pv.descRequired)
+		pv.descRequired,
+		pv.normalizeStatus,
+		pv.checkStatus,
+		pv.setPublishAt)
 
 	if err != nil {
 		return err
@@ -43,6 +54,33 @@ func (pv *postValidator) Delete(id uint) error {
 	return pv.PostDB.Delete(post.ID)
 }
 
+func (pv *postValidator) GetPageByUserId(userId uint, publishedOnly bool, limit, offset int) ([]Post, error) {
+	if userId <= 0 {
+		return nil, ErrInvalidID
+	}
+	return pv.PostDB.GetPageByUserId(userId, publishedOnly, normalizeLimit(limit), normalizeOffset(offset))
+}
+
+func (pv *postValidator) GetFeed(userId uint, after *FeedCursor, limit int) ([]Post, error) {
+	if userId <= 0 {
+		return nil, ErrInvalidID
+	}
+	return pv.PostDB.GetFeed(userId, after, normalizeLimit(limit))
+}
+
+func (pv *postValidator) Restore(id uint) error {
+	var post Post
+	post.ID = id
+
+	err := postValidationFuncs(&post, pv.validId)
+
+	if err != nil {
+		return err
+	}
+
+	return pv.PostDB.Restore(post.ID)
+}
+
 ///////////////////////////////////////////////////////////
 // Private functions
 ///////////////////////////////////////////////////////////
@@ -68,6 +106,47 @@ func (pv *postValidator) descRequired(post *Post) error {
 	return nil
 }
 
+// Posts without a status are published right away, unless they
+// come with a publish time in the future
+func (pv *postValidator) normalizeStatus(post *Post) error {
+	post.Status = strings.ToLower(strings.TrimSpace(post.Status))
+	if post.Status != "" {
+		return nil
+	}
+	if post.PublishAt != nil && post.PublishAt.After(time.Now()) {
+		post.Status = PostScheduled
+	} else {
+		post.Status = PostPublished
+	}
+	return nil
+}
+
+func (pv *postValidator) checkStatus(post *Post) error {
+	switch post.Status {
+	case PostDraft, PostPublished, PostArchived:
+		return nil
+	case PostScheduled:
+		if post.PublishAt == nil {
+			return ErrPublishAtRequired
+		}
+		return nil
+	}
+	return ErrStatusInvalid
+}
+
+// Published posts record when they went public, and scheduled
+// posts that are already due get published immediately
+func (pv *postValidator) setPublishAt(post *Post) error {
+	now := time.Now()
+	if post.Status == PostScheduled && !post.PublishAt.After(now) {
+		post.Status = PostPublished
+	}
+	if post.Status == PostPublished && (post.PublishAt == nil || post.PublishAt.After(now)) {
+		post.PublishAt = &now
+	}
+	return nil
+}
+
 func (pv *postValidator) validId(post *Post) error {
 	if post.ID <= 0 {
 		return ErrInvalidID
@@ -0,0 +1,31 @@
+package models
+
+import (
+	"time"
+)
+
+// Reaction kinds users can leave on a post
+const (
+	ReactionLike  = "like"
+	ReactionLove  = "love"
+	ReactionLaugh = "laugh"
+	ReactionWow   = "wow"
+)
+
+// Column on the posts table that counts each kind of reaction
+var reactionCounterColumns = map[string]string{
+	ReactionLike:  "like_count",
+	ReactionLove:  "love_count",
+	ReactionLaugh: "laugh_count",
+	ReactionWow:   "wow_count",
+}
+
+// A user can leave each kind of reaction at most once per post,
+// so reactions are hard-deleted instead of soft-deleted.
+type Reaction struct {
+	ID        uint `gorm:"primary_key"`
+	CreatedAt time.Time
+	PostID    uint   `gorm:"not null; unique_index:idx_reactions_post_user_kind"`
+	UserID    uint   `gorm:"not null; unique_index:idx_reactions_post_user_kind"`
+	Kind      string `gorm:"not null; unique_index:idx_reactions_post_user_kind"`
+}
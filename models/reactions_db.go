@@ -0,0 +1,80 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type reactionDB interface {
+	GetKindsByUser(postID, userID uint) ([]string, error)
+	// Create and Delete are idempotent and keep the
+	// counters on the post in sync
+	Create(reaction *Reaction) error
+	Delete(reaction *Reaction) error
+}
+
+type reactionGorm struct {
+	db *gorm.DB
+}
+
+var _ reactionDB = &reactionGorm{}
+
+func (rg *reactionGorm) GetKindsByUser(postID, userID uint) ([]string, error) {
+	var kinds []string
+	err := rg.db.Model(&Reaction{}).
+		Where("post_id = ? AND user_id = ?", postID, userID).
+		Order("kind").
+		Pluck("kind", &kinds).Error
+	if err != nil {
+		return nil, err
+	}
+	return kinds, nil
+}
+
+func (rg *reactionGorm) Create(reaction *Reaction) error {
+	return transaction(rg.db, func(tx *gorm.DB) error {
+		db := exec(tx, "INSERT INTO reactions (created_at, post_id, user_id, kind) VALUES (?, ?, ?, ?) ON CONFLICT DO NOTHING",
+			time.Now(), reaction.PostID, reaction.UserID, reaction.Kind)
+		if db.Error != nil {
+			return db.Error
+		}
+		// Already reacted, nothing to count
+		if db.RowsAffected == 0 {
+			return nil
+		}
+		column := reactionCounterColumns[reaction.Kind]
+		return exec(tx, "UPDATE posts SET "+column+" = "+column+" + 1 WHERE id = ?", reaction.PostID).Error
+	})
+}
+
+func (rg *reactionGorm) Delete(reaction *Reaction) error {
+	return transaction(rg.db, func(tx *gorm.DB) error {
+		db := exec(tx, "DELETE FROM reactions WHERE post_id = ? AND user_id = ? AND kind = ?",
+			reaction.PostID, reaction.UserID, reaction.Kind)
+		if db.Error != nil {
+			return db.Error
+		}
+		if db.RowsAffected == 0 {
+			return nil
+		}
+		column := reactionCounterColumns[reaction.Kind]
+		return exec(tx, "UPDATE posts SET "+column+" = "+column+" - 1 WHERE id = ?", reaction.PostID).Error
+	})
+}
+
+// Deletes every reaction left by the users selected by usersQuery
+// (a SELECT returning user ids) and takes them off the post counters.
+// It is meant to be run inside a transaction.
+func deleteReactionsByUsers(tx *gorm.DB, usersQuery string, args ...interface{}) error {
+	for kind, column := range reactionCounterColumns {
+		err := exec(tx, "UPDATE posts SET "+column+" = "+column+" - r.n "+
+			"FROM (SELECT post_id, count(*) AS n FROM reactions WHERE kind = ? AND user_id IN ("+usersQuery+") GROUP BY post_id) r "+
+			"WHERE posts.id = r.post_id",
+			append([]interface{}{kind}, args...)...).Error
+		if err != nil {
+			return err
+		}
+	}
+	return exec(tx, "DELETE FROM reactions WHERE user_id IN ("+usersQuery+")", args...).Error
+}
@@ -0,0 +1,60 @@
+package models
+
+type reactionValidator struct {
+	reactionDB
+}
+
+func (rv *reactionValidator) Create(reaction *Reaction) error {
+	err := runReactionValFuncs(reaction,
+		rv.requireUserID,
+		rv.requirePostID,
+		rv.checkKind)
+	if err != nil {
+		return err
+	}
+	return rv.reactionDB.Create(reaction)
+}
+
+func (rv *reactionValidator) Delete(reaction *Reaction) error {
+	err := runReactionValFuncs(reaction,
+		rv.requireUserID,
+		rv.requirePostID,
+		rv.checkKind)
+	if err != nil {
+		return err
+	}
+	return rv.reactionDB.Delete(reaction)
+}
+
+func (rv *reactionValidator) requireUserID(reaction *Reaction) error {
+	if reaction.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (rv *reactionValidator) requirePostID(reaction *Reaction) error {
+	if reaction.PostID <= 0 {
+		return ErrInvalidID
+	}
+	return nil
+}
+
+func (rv *reactionValidator) checkKind(reaction *Reaction) error {
+	if _, ok := reactionCounterColumns[reaction.Kind]; !ok {
+		return ErrReactionKindInvalid
+	}
+	return nil
+}
+
+type reactionValFunc func(*Reaction) error
+
+func runReactionValFuncs(reaction *Reaction, fns ...reactionValFunc) error {
+	for _, fn := range fns {
+		err := fn(reaction)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
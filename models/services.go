@@ -1,11 +1,24 @@
 package models
 
-import "github.com/jinzhu/gorm"
+import (
+	"fmt"
+	"log/slog"
+	"strings"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
 
 type Services struct {
-	User UserService
-	Post PostService
-	db   *gorm.DB
+	User       UserService
+	Post       PostService
+	Follow     FollowService
+	Attachment AttachmentService
+	Account    AccountService
+	Identity   IdentityService
+	Session    SessionService
+	db         *gorm.DB
+	logger     *slog.Logger
 }
 
 type ServicesConfig func(*Services) error
@@ -28,10 +41,15 @@ func (services *Services) Close() error {
 	return services.db.Close()
 }
 
+// Models with a table, see AutoMigrate
+func migratedModels() []interface{} {
+	return []interface{}{&User{}, &Post{}, &passwordReset{}, &Reaction{}, &Follow{}, &emailChange{}, &Attachment{}, &DataExport{}, &magicLink{}, &Identity{}, &Session{}}
+}
+
 // For development, testing only
 // Recreate tables
 func (services *Services) DestructiveReset() error {
-	err := services.db.DropTableIfExists(&User{}, &Post{}, &passwordReset{}).Error
+	err := services.db.DropTableIfExists(migratedModels()...).Error
 	if err != nil {
 		return err
 	}
@@ -40,24 +58,208 @@ func (services *Services) DestructiveReset() error {
 
 // Auto-migrate tables
 func (services *Services) AutoMigrate() error {
-	err := services.db.AutoMigrate(&User{}, &Post{}, &passwordReset{}).Error
+	err := services.db.AutoMigrate(migratedModels()...).Error
+	if err != nil {
+		return err
+	}
+
+	// Feed pages are read per author in publish order
+	err = services.db.Model(&Post{}).
+		AddIndex("idx_posts_user_id_publish_at", "user_id", "publish_at", "id").Error
+	if err != nil {
+		return err
+	}
+
+	err = migrateLowerUsernameIndex(services.db)
+	if err != nil {
+		return err
+	}
+
+	// Only remember token sessions have a token hash
+	err = exec(services.db, "CREATE UNIQUE INDEX IF NOT EXISTS idx_sessions_token_hash ON sessions (token_hash) WHERE token_hash <> ''").Error
 	if err != nil {
 		return err
 	}
-	return nil
+
+	// Remember tokens are kept by sessions, not users
+	err = exec(services.db, "ALTER TABLE users DROP COLUMN IF EXISTS token_hash").Error
+	if err != nil {
+		return err
+	}
+
+	// Posts created before publish times existed were published
+	// when they were created
+	err = exec(services.db, "UPDATE posts SET publish_at = created_at WHERE status = ? AND publish_at IS NULL",
+		PostPublished).Error
+	if err != nil {
+		return err
+	}
+
+	// Feed pages are read after the publish time of the last post,
+	// which published posts must have
+	return exec(services.db, `DO $$ BEGIN
+		IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conname = 'posts_published_publish_at') THEN
+			ALTER TABLE posts ADD CONSTRAINT posts_published_publish_at
+				CHECK (status <> '`+PostPublished+`' OR publish_at IS NOT NULL);
+		END IF;
+	END $$`).Error
 }
 
-func WithGorm(dialect, connectionInfo string) ServicesConfig {
+// Usernames are unique ignoring case. Accounts from before that
+// which only differ in case have to be renamed by hand first, as
+// silently renaming users would lock them out.
+func migrateLowerUsernameIndex(db *gorm.DB) error {
+	var duplicates []string
+	err := db.Table("users").
+		Where("username <> ''").
+		Group("lower(username)").
+		Having("count(*) > 1").
+		Pluck("lower(username)", &duplicates).Error
+	if err != nil {
+		return err
+	}
+	if len(duplicates) > 0 {
+		return fmt.Errorf("models: usernames %s are used by more than one account ignoring case; rename all but one of each before migrating",
+			strings.Join(duplicates, ", "))
+	}
+	return exec(db, "CREATE UNIQUE INDEX IF NOT EXISTS idx_users_lower_username ON users (lower(username))").Error
+}
+
+// PurgeDeleted permanently removes posts and users that were
+// soft-deleted before the given time, together with the rows that
+// depend on them, and password resets that were already used.
+// It returns the blob keys of the purged attachments and data
+// exports, so that their files can be removed from the blob store.
+func (services *Services) PurgeDeleted(before time.Time) ([]string, error) {
+	var blobKeys []string
+	err := transaction(services.db, func(tx *gorm.DB) error {
+		purgedUsers := "SELECT id FROM users WHERE deleted_at < ?"
+		purgedPosts := "SELECT id FROM posts WHERE deleted_at < ? OR user_id IN (" + purgedUsers + ")"
+
+		err := deleteReactionsByUsers(tx, purgedUsers, before)
+		if err != nil {
+			return err
+		}
+
+		err = exec(tx, "DELETE FROM reactions WHERE post_id IN ("+purgedPosts+")",
+			before, before).Error
+		if err != nil {
+			return err
+		}
+
+		var attachments []Attachment
+		err = tx.Unscoped().Where("post_id IN ("+purgedPosts+")", before, before).
+			Find(&attachments).Error
+		if err != nil {
+			return err
+		}
+		for _, attachment := range attachments {
+			blobKeys = append(blobKeys, attachment.BlobKey)
+			if attachment.ThumbnailKey != "" {
+				blobKeys = append(blobKeys, attachment.ThumbnailKey)
+			}
+		}
+
+		err = exec(tx, "DELETE FROM attachments WHERE post_id IN ("+purgedPosts+")",
+			before, before).Error
+		if err != nil {
+			return err
+		}
+
+		err = exec(tx, "DELETE FROM follows WHERE follower_id IN ("+purgedUsers+") OR followee_id IN ("+purgedUsers+")",
+			before, before).Error
+		if err != nil {
+			return err
+		}
+
+		err = exec(tx, "DELETE FROM password_resets WHERE deleted_at < ? OR user_id IN ("+purgedUsers+")",
+			before, before).Error
+		if err != nil {
+			return err
+		}
+
+		err = exec(tx, "DELETE FROM email_changes WHERE deleted_at < ? OR user_id IN ("+purgedUsers+")",
+			before, before).Error
+		if err != nil {
+			return err
+		}
+
+		err = exec(tx, "DELETE FROM magic_links WHERE deleted_at < ? OR user_id IN ("+purgedUsers+")",
+			before, before).Error
+		if err != nil {
+			return err
+		}
+
+		err = exec(tx, "DELETE FROM identities WHERE user_id IN ("+purgedUsers+")", before).Error
+		if err != nil {
+			return err
+		}
+
+		err = exec(tx, "DELETE FROM sessions WHERE user_id IN ("+purgedUsers+")", before).Error
+		if err != nil {
+			return err
+		}
+
+		var exportKeys []string
+		err = tx.Model(&DataExport{}).Where("user_id IN ("+purgedUsers+")", before).
+			Pluck("blob_key", &exportKeys).Error
+		if err != nil {
+			return err
+		}
+		blobKeys = append(blobKeys, exportKeys...)
+
+		err = exec(tx, "DELETE FROM data_exports WHERE user_id IN ("+purgedUsers+")", before).Error
+		if err != nil {
+			return err
+		}
+
+		err = exec(tx, "DELETE FROM posts WHERE deleted_at < ? OR user_id IN ("+purgedUsers+")",
+			before, before).Error
+		if err != nil {
+			return err
+		}
+
+		return exec(tx, "DELETE FROM users WHERE deleted_at < ?", before).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+	return blobKeys, nil
+}
+
+// PoolConfig sizes the pool of database connections. Zero
+// MaxOpenConns means no limit, and zero ConnMaxLifetime that
+// connections are reused forever.
+type PoolConfig struct {
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+}
+
+func WithGorm(dialect, connectionInfo string, pool PoolConfig) ServicesConfig {
 	return func(s *Services) error {
 		db, err := gorm.Open(dialect, connectionInfo)
 		if err != nil {
 			return err
 		}
+		db.DB().SetMaxOpenConns(pool.MaxOpenConns)
+		db.DB().SetMaxIdleConns(pool.MaxIdleConns)
+		db.DB().SetConnMaxLifetime(pool.ConnMaxLifetime)
 		s.db = db
 		return nil
 	}
 }
 
+// WithLogger sets where services and GORM log to. It must come
+// before the services are created.
+func WithLogger(logger *slog.Logger) ServicesConfig {
+	return func(s *Services) error {
+		s.logger = logger
+		s.db.SetLogger(gormLogger{logger})
+		return nil
+	}
+}
+
 func WithLogMode(mode bool) ServicesConfig {
 	return func(s *Services) error {
 		s.db.LogMode(mode)
@@ -68,9 +270,10 @@ func WithLogMode(mode bool) ServicesConfig {
 /////////////////////////
 // Create each service //
 /////////////////////////
-func WithUser(pepper, hmacKey string) ServicesConfig {
+func WithUser(pepper, hmacKey string, cfgs ...UserServiceConfig) ServicesConfig {
 	return func(s *Services) error {
-		s.User = NewUserService(s.db, pepper, hmacKey)
+		cfgs = append([]UserServiceConfig{withUserLogger(s.logger)}, cfgs...)
+		s.User = NewUserService(s.db, pepper, hmacKey, cfgs...)
 		return nil
 	}
 }
@@ -81,3 +284,42 @@ func WithPost() ServicesConfig {
 		return nil
 	}
 }
+
+func WithFollow() ServicesConfig {
+	return func(s *Services) error {
+		s.Follow = NewFollowService(s.db)
+		return nil
+	}
+}
+
+func WithAttachment() ServicesConfig {
+	return func(s *Services) error {
+		s.Attachment = NewAttachmentService(s.db)
+		return nil
+	}
+}
+
+// WithIdentity needs the user service, see WithUser
+func WithIdentity() ServicesConfig {
+	return func(s *Services) error {
+		if s.User == nil {
+			return ErrServiceRequired
+		}
+		s.Identity = NewIdentityService(s.db, s.User)
+		return nil
+	}
+}
+
+func WithAccount() ServicesConfig {
+	return func(s *Services) error {
+		s.Account = NewAccountService(s.db)
+		return nil
+	}
+}
+
+func WithSession(hmacKey string) ServicesConfig {
+	return func(s *Services) error {
+		s.Session = NewSessionService(s.db, hmacKey)
+		return nil
+	}
+}
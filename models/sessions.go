@@ -0,0 +1,164 @@
+package models
+
+import (
+	"context"
+	"time"
+
+	"go_rest_pg_starter/utils"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Session is a token issued to a user when they sign in,
+// along with where it was issued and last used
+type Session struct {
+	ID         uint       `gorm:"primary_key" json:"id"`
+	CreatedAt  time.Time  `json:"created_at"`
+	UserID     uint       `gorm:"not null; index" json:"-"`
+	UserAgent  string     `gorm:"not null; default:''" json:"user_agent"`
+	IP         string     `gorm:"not null; default:''" json:"ip"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"index" json:"expires_at"`
+	RevokedAt  *time.Time `json:"-"`
+	// Set for browsers signed in with a remember token cookie,
+	// see SessionService.Remember
+	Token     string `gorm:"-" json:"-"`
+	TokenHash string `gorm:"not null; default:''" json:"-"`
+}
+
+// IsActive reports whether the session's token can still be used
+func (s *Session) IsActive(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// SessionService is a set of methods used to work with the
+// sessions users are signed in with
+type SessionService interface {
+	// Create records a newly issued token of the user, along
+	// with the device it was issued to
+	Create(user *User, userAgent, ip string, expiresAt time.Time) (*Session, error)
+	// Remember records a browser signed in with a remember token
+	// cookie, and sets the token of the session returned
+	Remember(user *User, userAgent, ip string, expiresAt time.Time) (*Session, error)
+	GetById(id uint) (*Session, error)
+	GetByToken(token string) (*Session, error)
+	// Touch records that the session was used by now
+	Touch(session *Session, now time.Time) error
+	// List returns the sessions of the user that are still
+	// active, most recently used first
+	List(userID uint) ([]Session, error)
+	// Revoke signs out a single session of the user. If the
+	// session does not belong to the user or was already
+	// revoked, ErrNotFound is returned.
+	Revoke(userID, id uint) error
+	// RevokeAll signs out every session of the user
+	RevokeAll(userID uint) error
+	// DeleteEnded deletes the sessions that expired or were
+	// revoked before the given time
+	DeleteEnded(before time.Time) (int64, error)
+	// WithContext returns the service for queries made on
+	// behalf of ctx, see WithTracer
+	WithContext(ctx context.Context) SessionService
+}
+
+func NewSessionService(db *gorm.DB, hmacKey string) SessionService {
+	return newSessionService(db, utils.NewHMAC(hmacKey))
+}
+
+func newSessionService(db *gorm.DB, hmac utils.HMAC) *sessionService {
+	return &sessionService{
+		sessionDB: &sessionValidator{
+			sessionDB: &sessionGorm{db},
+			hmac:      hmac,
+		},
+		db:   db,
+		hmac: hmac,
+	}
+}
+
+var _ SessionService = &sessionService{}
+
+type sessionService struct {
+	sessionDB sessionDB
+	db        *gorm.DB
+	hmac      utils.HMAC
+}
+
+func (ss *sessionService) WithContext(ctx context.Context) SessionService {
+	return newSessionService(withContext(ss.db, ctx), ss.hmac)
+}
+
+// How often the last seen time of a session is written at most,
+// so that not every request costs a write
+const sessionTouchInterval = time.Minute
+
+func (ss *sessionService) Create(user *User, userAgent, ip string, expiresAt time.Time) (*Session, error) {
+	session := Session{
+		UserID:     user.ID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		LastSeenAt: time.Now(),
+		ExpiresAt:  expiresAt,
+	}
+	err := ss.sessionDB.Create(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (ss *sessionService) Remember(user *User, userAgent, ip string, expiresAt time.Time) (*Session, error) {
+	token, err := utils.GenerateToken()
+	if err != nil {
+		return nil, err
+	}
+	session := Session{
+		UserID:     user.ID,
+		UserAgent:  userAgent,
+		IP:         ip,
+		LastSeenAt: time.Now(),
+		ExpiresAt:  expiresAt,
+		Token:      token,
+	}
+	err = ss.sessionDB.Create(&session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (ss *sessionService) GetById(id uint) (*Session, error) {
+	return ss.sessionDB.GetOneById(id)
+}
+
+func (ss *sessionService) GetByToken(token string) (*Session, error) {
+	return ss.sessionDB.GetOneByToken(token)
+}
+
+func (ss *sessionService) Touch(session *Session, now time.Time) error {
+	if now.Sub(session.LastSeenAt) < sessionTouchInterval {
+		return nil
+	}
+	err := ss.sessionDB.Touch(session.ID, now)
+	if err != nil {
+		return err
+	}
+	session.LastSeenAt = now
+	return nil
+}
+
+func (ss *sessionService) List(userID uint) ([]Session, error) {
+	return ss.sessionDB.GetActiveByUserId(userID, time.Now())
+}
+
+func (ss *sessionService) Revoke(userID, id uint) error {
+	return ss.sessionDB.Revoke(userID, id, time.Now())
+}
+
+func (ss *sessionService) RevokeAll(userID uint) error {
+	return ss.sessionDB.RevokeAllByUserId(userID, time.Now())
+}
+
+func (ss *sessionService) DeleteEnded(before time.Time) (int64, error) {
+	return ss.sessionDB.DeleteEndedBefore(before)
+}
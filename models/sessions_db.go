@@ -0,0 +1,90 @@
+package models
+
+import (
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+type sessionDB interface {
+	GetOneById(id uint) (*Session, error)
+	// Looks up the session of a remember token, by the
+	// hash of it once validated
+	GetOneByToken(token string) (*Session, error)
+	// Sessions that are neither revoked nor expired, most
+	// recently used first
+	GetActiveByUserId(userID uint, now time.Time) ([]Session, error)
+	Create(session *Session) error
+	Touch(id uint, now time.Time) error
+	Revoke(userID, id uint, now time.Time) error
+	RevokeAllByUserId(userID uint, now time.Time) error
+	// Deletes the sessions that expired or were revoked before
+	DeleteEndedBefore(before time.Time) (int64, error)
+}
+
+type sessionGorm struct {
+	db *gorm.DB
+}
+
+var _ sessionDB = &sessionGorm{}
+
+func (sg *sessionGorm) GetOneById(id uint) (*Session, error) {
+	var session Session
+	err := First(sg.db.Where("id = ?", id), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (sg *sessionGorm) GetOneByToken(tokenHash string) (*Session, error) {
+	var session Session
+	err := First(sg.db.Where("token_hash = ?", tokenHash), &session)
+	if err != nil {
+		return nil, err
+	}
+	return &session, nil
+}
+
+func (sg *sessionGorm) GetActiveByUserId(userID uint, now time.Time) ([]Session, error) {
+	var sessions []Session
+	err := sg.db.Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, now).
+		Order("last_seen_at DESC").
+		Find(&sessions).Error
+	if err != nil {
+		return nil, err
+	}
+	return sessions, nil
+}
+
+func (sg *sessionGorm) Create(session *Session) error {
+	return sg.db.Create(session).Error
+}
+
+func (sg *sessionGorm) Touch(id uint, now time.Time) error {
+	return sg.db.Model(&Session{}).Where("id = ?", id).UpdateColumn("last_seen_at", now).Error
+}
+
+func (sg *sessionGorm) Revoke(userID, id uint, now time.Time) error {
+	res := sg.db.Model(&Session{}).
+		Where("id = ? AND user_id = ? AND revoked_at IS NULL", id, userID).
+		UpdateColumn("revoked_at", now)
+	if res.Error != nil {
+		return res.Error
+	}
+	if res.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (sg *sessionGorm) RevokeAllByUserId(userID uint, now time.Time) error {
+	return sg.db.Model(&Session{}).
+		Where("user_id = ? AND revoked_at IS NULL", userID).
+		UpdateColumn("revoked_at", now).Error
+}
+
+func (sg *sessionGorm) DeleteEndedBefore(before time.Time) (int64, error) {
+	res := sg.db.Where("expires_at < ? OR revoked_at < ?", before, before).Delete(&Session{})
+	return res.RowsAffected, res.Error
+}
@@ -0,0 +1,83 @@
+package models
+
+import (
+	"unicode/utf8"
+
+	"go_rest_pg_starter/utils"
+)
+
+// Longest user agent kept, longer ones are cut
+const maxUserAgentLength = 255
+
+type sessionValidator struct {
+	sessionDB
+	hmac utils.HMAC
+}
+
+func (sv *sessionValidator) GetOneById(id uint) (*Session, error) {
+	if id <= 0 {
+		return nil, ErrInvalidID
+	}
+	return sv.sessionDB.GetOneById(id)
+}
+
+func (sv *sessionValidator) GetOneByToken(token string) (*Session, error) {
+	if token == "" {
+		return nil, ErrNotFound
+	}
+	return sv.sessionDB.GetOneByToken(sv.hmac.Hash(token))
+}
+
+func (sv *sessionValidator) Create(session *Session) error {
+	err := runSessionValFuncs(session,
+		sv.requireUserID,
+		sv.requireExpiry,
+		sv.truncateUserAgent,
+		sv.hmacToken)
+	if err != nil {
+		return err
+	}
+	return sv.sessionDB.Create(session)
+}
+
+func (sv *sessionValidator) requireUserID(session *Session) error {
+	if session.UserID <= 0 {
+		return ErrUserIDRequired
+	}
+	return nil
+}
+
+func (sv *sessionValidator) requireExpiry(session *Session) error {
+	if session.ExpiresAt.IsZero() {
+		return ErrSessionExpiryRequired
+	}
+	return nil
+}
+
+func (sv *sessionValidator) truncateUserAgent(session *Session) error {
+	if utf8.RuneCountInString(session.UserAgent) > maxUserAgentLength {
+		session.UserAgent = string([]rune(session.UserAgent)[:maxUserAgentLength])
+	}
+	return nil
+}
+
+// Only the hash of a remember token is stored
+func (sv *sessionValidator) hmacToken(session *Session) error {
+	if session.Token == "" {
+		return nil
+	}
+	session.TokenHash = sv.hmac.Hash(session.Token)
+	return nil
+}
+
+type sessionValFunc func(*Session) error
+
+func runSessionValFuncs(session *Session, fns ...sessionValFunc) error {
+	for _, fn := range fns {
+		err := fn(session)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,113 @@
+package models
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"go_rest_pg_starter/tracing"
+
+	"github.com/jinzhu/gorm"
+)
+
+// WithTracer records a span for each query made through GORM,
+// and raw SQL run with exec. Queries of a service returned by
+// WithContext are children of the context's span, others start
+// traces of their own. It must come before the services are
+// created.
+func WithTracer(tracer *tracing.Tracer) ServicesConfig {
+	return func(s *Services) error {
+		s.db = s.db.Set(tracerSetting, tracer)
+		callbacks := s.db.Callback()
+		callbacks.Create().Before("gorm:create").Register("tracing:before_create", startQuerySpan(tracer, "INSERT"))
+		callbacks.Create().After("gorm:create").Register("tracing:after_create", endQuerySpan)
+		callbacks.Query().Before("gorm:query").Register("tracing:before_query", startQuerySpan(tracer, "SELECT"))
+		callbacks.Query().After("gorm:query").Register("tracing:after_query", endQuerySpan)
+		callbacks.RowQuery().Before("gorm:row_query").Register("tracing:before_row_query", startQuerySpan(tracer, "SELECT"))
+		callbacks.RowQuery().After("gorm:row_query").Register("tracing:after_row_query", endQuerySpan)
+		callbacks.Update().Before("gorm:update").Register("tracing:before_update", startQuerySpan(tracer, "UPDATE"))
+		callbacks.Update().After("gorm:update").Register("tracing:after_update", endQuerySpan)
+		callbacks.Delete().Before("gorm:delete").Register("tracing:before_delete", startQuerySpan(tracer, "DELETE"))
+		callbacks.Delete().After("gorm:delete").Register("tracing:after_delete", endQuerySpan)
+		return nil
+	}
+}
+
+const (
+	querySpanKey   = "tracing:span"
+	tracerSetting  = "tracing:tracer"
+	contextSetting = "tracing:context"
+)
+
+// Returns db for queries made on behalf of ctx
+func withContext(db *gorm.DB, ctx context.Context) *gorm.DB {
+	return db.Set(contextSetting, ctx)
+}
+
+// The context queries of db are made on behalf of
+func queryContext(db *gorm.DB) context.Context {
+	if value, ok := db.Get(contextSetting); ok {
+		return value.(context.Context)
+	}
+	return context.Background()
+}
+
+func startQuerySpan(tracer *tracing.Tracer, operation string) func(*gorm.Scope) {
+	return func(scope *gorm.Scope) {
+		table := scope.TableName()
+		_, span := tracer.Start(queryContext(scope.DB()), operation+" "+table, tracing.SpanKindClient)
+		span.SetAttribute("db.system", "postgresql")
+		span.SetAttribute("db.operation", operation)
+		span.SetAttribute("db.sql.table", table)
+		scope.InstanceSet(querySpanKey, span)
+	}
+}
+
+func endQuerySpan(scope *gorm.Scope) {
+	value, ok := scope.InstanceGet(querySpanKey)
+	if !ok {
+		return
+	}
+	span := value.(*tracing.Span)
+	span.SetAttribute("db.statement", sanitizeSQL(scope.SQL))
+	span.SetAttribute("db.rows_affected", scope.DB().RowsAffected)
+	if err := scope.DB().Error; err != nil && err != gorm.ErrRecordNotFound {
+		span.SetError(err)
+	}
+	span.End()
+}
+
+// Runs raw SQL, which GORM has no callbacks for, in a span of
+// its own when tracing
+func exec(db *gorm.DB, sql string, values ...interface{}) *gorm.DB {
+	value, ok := db.Get(tracerSetting)
+	if !ok {
+		return db.Exec(sql, values...)
+	}
+	operation := strings.ToUpper(strings.Fields(sql)[0])
+	_, span := value.(*tracing.Tracer).Start(queryContext(db), operation, tracing.SpanKindClient)
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.operation", operation)
+	span.SetAttribute("db.statement", sanitizeSQL(sql))
+
+	result := db.Exec(sql, values...)
+	span.SetAttribute("db.rows_affected", result.RowsAffected)
+	if result.Error != nil {
+		span.SetError(result.Error)
+	}
+	span.End()
+	return result
+}
+
+var (
+	sqlStringLiteral = regexp.MustCompile(`'(?:[^']|'')*'`)
+	// Numbers not part of a name or a $1 placeholder
+	sqlNumberLiteral = regexp.MustCompile(`(^|[^$\w])\d+(?:\.\d+)?\b`)
+)
+
+// Values are bound as $1, $2... but literals written in the
+// query itself are replaced too, so no data ends up in traces
+func sanitizeSQL(sql string) string {
+	sql = sqlStringLiteral.ReplaceAllString(sql, "?")
+	return sqlNumberLiteral.ReplaceAllString(sql, "${1}?")
+}
@@ -1,13 +1,20 @@
 package models
 
 import (
+	"context"
+	"log/slog"
 	"time"
 
 	"go_rest_pg_starter/utils"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
-	"golang.org/x/crypto/bcrypt"
+)
+
+// User roles
+const (
+	RoleStandard = "standard"
+	RoleAdmin    = "admin"
 )
 
 type User struct {
@@ -16,9 +23,14 @@ type User struct {
 	Email        string `gorm:"not null; unique_index"`
 	Role         string `gorm:"not null; default:'standard'"`
 	Password     string `gorm:"-"`
-	PasswordHash string `gorm:"not null`
-	Token        string `gorm:"-"`
-	TokenHash    string `gorm:"not null; unique_index"`
+	PasswordHash string `gorm:"not null"`
+	// Set for users created by signing in with an identity
+	// provider, who have no password until they set one
+	ExternalLogin bool `gorm:"not null; default:false"`
+	// Public profile
+	DisplayName string `gorm:"not null; default:''"`
+	Bio         string `gorm:"not null; default:''"`
+	AvatarURL   string `gorm:"not null; default:''"`
 }
 
 // UserService is a set of methods used to manipulate and
@@ -28,57 +40,162 @@ type UserService interface {
 	UserDB
 	InitiateReset(email string) (string, error)
 	CompleteReset(token, newPassword string) (*User, error)
+	InitiateMagicLogin(email string) (string, error)
+	CompleteMagicLogin(token string) (*User, error)
+	DeleteExpiredTokens(now time.Time) (int64, error)
+	ChangePassword(user *User, currentPassword, newPassword string) error
+	InitiateEmailChange(user *User, newEmail string) (string, error)
+	CompleteEmailChange(token string) (user *User, oldEmail string, err error)
+	CancelEmailChange(userID uint) error
+	// WithContext returns the service for queries made on
+	// behalf of ctx, see WithTracer
+	WithContext(ctx context.Context) UserService
+}
+
+// How long emailed tokens are valid by default
+const (
+	DefaultPasswordResetTTL = time.Hour
+	DefaultMagicLinkTTL     = 15 * time.Minute
+)
+
+type UserServiceConfig func(*userService)
+
+// WithPasswordResetTTL sets how long password reset tokens are
+// valid. Non-positive values keep the default.
+func WithPasswordResetTTL(ttl time.Duration) UserServiceConfig {
+	return func(us *userService) {
+		if ttl > 0 {
+			us.passwordResetTTL = ttl
+		}
+	}
+}
+
+// WithMagicLinkTTL sets how long magic login links are
+// valid. Non-positive values keep the default.
+func WithMagicLinkTTL(ttl time.Duration) UserServiceConfig {
+	return func(us *userService) {
+		if ttl > 0 {
+			us.magicLinkTTL = ttl
+		}
+	}
 }
 
-func NewUserService(db *gorm.DB, pepper, hmacKey string) UserService {
-	ug := &userGorm{db}
-	hmac := utils.NewHMAC(hmacKey)
-	uv := newUserValidator(ug, hmac, pepper)
+// WithPasswordHashing sets how password hashes are made
+func WithPasswordHashing(cfg PasswordHashConfig) UserServiceConfig {
+	return func(us *userService) {
+		us.passwordHashConfig = cfg
+	}
+}
 
-	return &userService{
-		UserDB:          uv,
-		pepper:          pepper,
-		passwordResetDB: newPasswordResetValidator(&passwordResetGorm{db}, hmac),
+func withUserLogger(logger *slog.Logger) UserServiceConfig {
+	return func(us *userService) {
+		us.logger = logger
 	}
 }
 
+// WithPasswordPolicy sets the rules new passwords must follow
+func WithPasswordPolicy(policy PasswordPolicy) UserServiceConfig {
+	return func(us *userService) {
+		us.passwordPolicy = policy
+	}
+}
+
+func NewUserService(db *gorm.DB, pepper, hmacKey string, cfgs ...UserServiceConfig) UserService {
+	us := &userService{
+		passwordHashConfig: DefaultPasswordHashConfig(),
+		passwordPolicy:     DefaultPasswordPolicy(),
+		passwordResetTTL:   DefaultPasswordResetTTL,
+		magicLinkTTL:       DefaultMagicLinkTTL,
+	}
+	for _, cfg := range cfgs {
+		cfg(us)
+	}
+	us.logger = defaultLogger(us.logger)
+	us.passwordPolicy.logger = us.logger
+	us.hasher = newPasswordHasher(us.passwordHashConfig, pepper)
+	us.hmac = utils.NewHMAC(hmacKey)
+	us.setDB(db)
+	return us
+}
+
+// Sets up the layers of the service making queries with db
+func (us *userService) setDB(db *gorm.DB) {
+	uv := newUserValidator(&userGorm{db}, us.hasher, us.passwordPolicy)
+	us.db = db
+	us.UserDB = uv
+	us.passwordResetDB = newPasswordResetValidator(&passwordResetGorm{db}, us.hmac)
+	us.magicLinkDB = newMagicLinkValidator(&magicLinkGorm{db}, us.hmac)
+	us.emailChangeDB = newEmailChangeValidator(&emailChangeGorm{db}, us.hmac, uv)
+}
+
+func (us *userService) WithContext(ctx context.Context) UserService {
+	clone := *us
+	clone.setDB(withContext(us.db, ctx))
+	return &clone
+}
+
 var _ UserService = &userService{}
 
 type userService struct {
 	UserDB
-	pepper          string
-	passwordResetDB passwordResetDB
+	db                 *gorm.DB
+	hmac               utils.HMAC
+	logger             *slog.Logger
+	passwordHashConfig PasswordHashConfig
+	hasher             *passwordHasher
+	passwordPolicy     PasswordPolicy
+	passwordResetDB    passwordResetDB
+	passwordResetTTL   time.Duration
+	magicLinkDB        magicLinkDB
+	magicLinkTTL       time.Duration
+	emailChangeDB      emailChangeDB
 }
 
 // Authenticate user. Checks email and password.
+// A password hash made with outdated parameters or an old
+// pepper is replaced by an up to date one.
 func (us *userService) Authenticate(email, password string) (*User, error) {
 	user, err := us.GetByEmail(email)
 	if err != nil {
 		return nil, err
 	}
 
-	err = bcrypt.CompareHashAndPassword(
-		[]byte(user.PasswordHash),
-		[]byte(password+us.pepper),
-	)
-
-	if err != nil {
+	ok, needsRehash, err := us.hasher.Verify(password, user.PasswordHash)
+	if err != nil || !ok {
 		return nil, ErrInvalidEmailOrPassword
 	}
 
+	if needsRehash {
+		// Logging in must not fail because of the upgrade
+		hash, err := us.hasher.Hash(password)
+		if err == nil {
+			user.PasswordHash = hash
+			err = us.Update(user)
+		}
+		if err != nil {
+			us.logger.Error("could not upgrade the password hash", "user_id", user.ID, "error", err)
+		}
+	}
+
 	return user, nil
 }
 
 // InitiateReset will complete all the model-related tasks
 // to start the password reset process for the user with
-// the provided email address. Once completed, it will
-// return the token, or an error if there was one.
+// the provided email address. Only the latest token issued
+// for a user is valid. Once completed, it will return the
+// token, or an error if there was one.
 func (us *userService) InitiateReset(email string) (string, error) {
 	user, err := us.GetByEmail(email)
 	if err != nil {
 		return "", err
 	}
 
+	err = us.passwordResetDB.DeleteByUserId(user.ID)
+	if err != nil {
+		return "", err
+	}
+
 	pwr := passwordReset{
 		UserID: user.ID,
 	}
@@ -92,10 +209,16 @@ func (us *userService) InitiateReset(email string) (string, error) {
 
 // CompleteReset will complete all the model-related tasks
 // to complete the password reset process for the user that
-// the token matches, including updating that user's pw.
+// the token matches, including updating that user's pw and
+// signing out every session. A new password the policy
+// rejects does not use the token up.
 // If the token has expired, or if it is invalid for any
 // other reason the ErrTokenInvalid error will be returned.
 func (us *userService) CompleteReset(token, newPw string) (*User, error) {
+	if newPw == "" {
+		return nil, ErrPasswordRequired
+	}
+
 	pwr, err := us.passwordResetDB.GetOneByToken(token)
 	if err != nil {
 		if err == ErrNotFound {
@@ -104,22 +227,199 @@ func (us *userService) CompleteReset(token, newPw string) (*User, error) {
 		return nil, err
 	}
 
-	// If the password rest is over 12 hours old, it is invalid
-	if time.Now().Sub(pwr.CreatedAt) > (12 * time.Hour) {
+	if time.Now().Sub(pwr.CreatedAt) > us.passwordResetTTL {
 		return nil, ErrTokenInvalid
 	}
 
 	user, err := us.GetById(pwr.UserID)
 	if err != nil {
+		// The user was deleted since the token was issued
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
 		return nil, err
 	}
 
+	// A refused password leaves the token usable for another try
 	user.Password = newPw
-	err = us.Update(user)
+	err = us.passwordPolicy.Check(user)
+	if err != nil {
+		return nil, err
+	}
+
+	err = transaction(us.db, func(tx *gorm.DB) error {
+		// Only one of concurrent requests gets to use the token
+		_, err := newPasswordResetValidator(&passwordResetGorm{tx}, us.hmac).Consume(token)
+		if err != nil {
+			if err == ErrNotFound {
+				return ErrTokenInvalid
+			}
+			return err
+		}
+		return us.updateSignedOut(tx, user)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// InitiateMagicLogin issues a token the user with the provided
+// email address can sign in with once, instead of their
+// password. Only the latest token issued for a user is valid.
+func (us *userService) InitiateMagicLogin(email string) (string, error) {
+	user, err := us.GetByEmail(email)
+	if err != nil {
+		return "", err
+	}
+
+	err = us.magicLinkDB.DeleteByUserId(user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	ml := magicLink{
+		UserID: user.ID,
+	}
+	err = us.magicLinkDB.Create(&ml)
+	if err != nil {
+		return "", err
+	}
+	return ml.Token, nil
+}
+
+// CompleteMagicLogin uses up the token and returns the user it
+// was issued for. If the token has expired, or if it is invalid
+// for any other reason the ErrTokenInvalid error will be returned.
+func (us *userService) CompleteMagicLogin(token string) (*User, error) {
+	ml, err := us.magicLinkDB.Consume(token)
 	if err != nil {
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
 		return nil, err
 	}
 
-	us.passwordResetDB.Delete(pwr.ID)
+	if time.Now().Sub(ml.CreatedAt) > us.magicLinkTTL {
+		return nil, ErrTokenInvalid
+	}
+
+	user, err := us.GetById(ml.UserID)
+	if err != nil {
+		// The user was deleted since the token was issued
+		if err == ErrNotFound {
+			return nil, ErrTokenInvalid
+		}
+		return nil, err
+	}
 	return user, nil
 }
+
+// DeleteExpiredTokens deletes the password resets and magic
+// links whose token has expired by now
+func (us *userService) DeleteExpiredTokens(now time.Time) (int64, error) {
+	resets, err := us.passwordResetDB.DeleteCreatedBefore(now.Add(-us.passwordResetTTL))
+	if err != nil {
+		return 0, err
+	}
+	links, err := us.magicLinkDB.DeleteCreatedBefore(now.Add(-us.magicLinkTTL))
+	if err != nil {
+		return resets, err
+	}
+	return resets + links, nil
+}
+
+// ChangePassword replaces the user's password once the current
+// one is verified. Every session issued before is signed out,
+// so the caller should issue a new token.
+func (us *userService) ChangePassword(user *User, currentPw, newPw string) error {
+	_, err := us.Authenticate(user.Email, currentPw)
+	if err != nil {
+		return err
+	}
+	if newPw == "" {
+		return ErrPasswordRequired
+	}
+
+	user.Password = newPw
+	return transaction(us.db, func(tx *gorm.DB) error {
+		return us.updateSignedOut(tx, user)
+	})
+}
+
+// InitiateEmailChange validates the new email address and
+// records it as pending until it is confirmed. It returns the
+// token to send to the new address.
+func (us *userService) InitiateEmailChange(user *User, newEmail string) (string, error) {
+	// Only the latest requested change can be confirmed
+	err := us.emailChangeDB.DeleteByUserId(user.ID)
+	if err != nil {
+		return "", err
+	}
+
+	ec := emailChange{
+		UserID:   user.ID,
+		NewEmail: newEmail,
+	}
+	err = us.emailChangeDB.Create(&ec)
+	if err != nil {
+		return "", err
+	}
+	return ec.Token, nil
+}
+
+// CancelEmailChange drops the pending email change of the user,
+// if any
+func (us *userService) CancelEmailChange(userID uint) error {
+	return us.emailChangeDB.DeleteByUserId(userID)
+}
+
+// CompleteEmailChange swaps the user's email for the pending
+// one the token was issued for, and signs out every session of
+// the user. The replaced address is returned so it can be told
+// about the change. If the token has expired or is invalid for
+// any other reason ErrTokenInvalid is returned.
+func (us *userService) CompleteEmailChange(token string) (*User, string, error) {
+	ec, err := us.emailChangeDB.GetOneByToken(token)
+	if err != nil {
+		if err == ErrNotFound {
+			return nil, "", ErrTokenInvalid
+		}
+		return nil, "", err
+	}
+
+	// If the email change is over 24 hours old, it is invalid
+	if time.Now().Sub(ec.CreatedAt) > (24 * time.Hour) {
+		return nil, "", ErrTokenInvalid
+	}
+
+	user, err := us.GetById(ec.UserID)
+	if err != nil {
+		return nil, "", err
+	}
+
+	// Availability of the address is checked again on update
+	oldEmail := user.Email
+	user.Email = ec.NewEmail
+	err = transaction(us.db, func(tx *gorm.DB) error {
+		return us.updateSignedOut(tx, user)
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	us.emailChangeDB.DeleteByUserId(user.ID)
+	return user, oldEmail, nil
+}
+
+// Saves the user's new credentials and signs out every session
+// issued before them as part of the transaction, so that neither
+// is saved without the other
+func (us *userService) updateSignedOut(tx *gorm.DB, user *User) error {
+	uv := newUserValidator(&userGorm{tx}, us.hasher, us.passwordPolicy)
+	err := uv.Update(user)
+	if err != nil {
+		return err
+	}
+	return (&sessionGorm{tx}).RevokeAllByUserId(user.ID, time.Now())
+}
@@ -16,11 +16,12 @@ type UserDB interface {
 	// Reader
 	GetById(id uint) (*User, error)
 	GetByEmail(email string) (*User, error)
-	GetByToken(token string) (*User, error)
+	GetByUsername(username string) (*User, error)
 	// Writer
 	Create(user *User) error
 	Update(user *User) error
 	Delete(id uint) error
+	Restore(id uint) error
 }
 
 // Get an user by id
@@ -45,10 +46,10 @@ func (ug *userGorm) GetByEmail(email string) (*User, error) {
 	return &user, nil
 }
 
-// Get an user by token
-func (ug *userGorm) GetByToken(tokenHash string) (*User, error) {
+// Get an user by username, ignoring case
+func (ug *userGorm) GetByUsername(username string) (*User, error) {
 	var user User
-	db := ug.db.Where("token_hash = ?", tokenHash)
+	db := ug.db.Where("lower(username) = ?", username)
 	err := First(db, &user)
 	if err != nil {
 		return nil, err
@@ -73,3 +74,17 @@ func (ug *userGorm) Delete(id uint) error {
 	}
 	return ug.db.Delete(user).Error
 }
+
+// Restore a soft-deleted user
+func (ug *userGorm) Restore(id uint) error {
+	db := ug.db.Unscoped().Model(&User{}).
+		Where("id = ? AND deleted_at IS NOT NULL", id).
+		Update("deleted_at", nil)
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
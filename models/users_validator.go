@@ -2,12 +2,10 @@ package models
 
 import (
 	"errors"
+	"net/url"
 	"regexp"
 	"strings"
-
-	"go_rest_pg_starter/utils"
-
-	"golang.org/x/crypto/bcrypt"
+	"unicode/utf8"
 )
 
 // userValidator is our validation layer that validates
@@ -15,17 +13,25 @@ import (
 // UserDB in our interface chain.
 type userValidator struct {
 	UserDB
-	hmac       utils.HMAC
-	pepper     string
-	emailRegex *regexp.Regexp
+	hasher         *passwordHasher
+	passwordPolicy PasswordPolicy
+	emailRegex     *regexp.Regexp
+	usernameRegex  *regexp.Regexp
 }
 
-func newUserValidator(udb UserDB, hmac utils.HMAC, pepper string) *userValidator {
+var (
+	emailRegex = regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`)
+	// Starting with a letter keeps usernames apart from numeric ids in urls
+	usernameRegex = regexp.MustCompile(`^[a-z][a-z0-9_]{2,29}$`)
+)
+
+func newUserValidator(udb UserDB, hasher *passwordHasher, policy PasswordPolicy) *userValidator {
 	return &userValidator{
-		UserDB:     udb,
-		hmac:       hmac,
-		pepper:     pepper,
-		emailRegex: regexp.MustCompile(`^[a-z0-9._%+\-]+@[a-z0-9.\-]+\.[a-z]{2,16}$`),
+		UserDB:         udb,
+		hasher:         hasher,
+		passwordPolicy: policy,
+		emailRegex:     emailRegex,
+		usernameRegex:  usernameRegex,
 	}
 }
 
@@ -36,34 +42,35 @@ func (uv *userValidator) GetById(id uint) (*User, error) {
 	return uv.UserDB.GetById(id)
 }
 
-func (uv *userValidator) GetByToken(token string) (*User, error) {
-	user := User{Token: token}
-	err := userValidationFuncs(&user, uv.hmacHashToken)
+func (uv *userValidator) GetByEmail(email string) (*User, error) {
+	user := User{Email: email}
+	err := userValidationFuncs(&user, uv.normalizeEmail)
 	if err != nil {
 		return nil, err
 	}
-	return uv.UserDB.GetByToken(user.TokenHash)
+	return uv.UserDB.GetByEmail(user.Email)
 }
 
-func (uv *userValidator) GetByEmail(email string) (*User, error) {
-	user := User{Email: email}
-	err := userValidationFuncs(&user, uv.normalizeEmail)
+func (uv *userValidator) GetByUsername(username string) (*User, error) {
+	user := User{Username: username}
+	err := userValidationFuncs(&user, uv.normalizeUsername)
 	if err != nil {
 		return nil, err
 	}
-	return uv.UserDB.GetByEmail(user.Email)
+	return uv.UserDB.GetByUsername(user.Username)
 }
 
 func (uv *userValidator) Create(user *User) error {
 	err := userValidationFuncs(user,
+		uv.normalizeUsername,
+		uv.requireUsername,
+		uv.checkUsernameFormat,
+		uv.checkUsernameAvailability,
+		uv.checkProfile,
 		uv.passwordRequired,
-		uv.passwordMinLength,
+		uv.checkPasswordPolicy,
 		uv.generatePasswordHash,
 		uv.passwordHashRequired,
-		uv.setTokenIfNotSet,
-		uv.tokenMinBytes,
-		uv.hmacHashToken,
-		uv.tokenHashRequired,
 		uv.requireEmail,
 		uv.normalizeEmail,
 		uv.checkEmailFormat,
@@ -77,12 +84,13 @@ func (uv *userValidator) Create(user *User) error {
 
 func (uv *userValidator) Update(user *User) error {
 	err := userValidationFuncs(user,
-		uv.passwordMinLength,
+		uv.checkChangedUsername,
+		uv.checkProfile,
+		uv.checkPasswordPolicy,
 		uv.generatePasswordHash,
+		// Users signing in with an identity provider only
+		// have no password hash
 		uv.passwordHashRequired,
-		uv.tokenMinBytes,
-		uv.hmacHashToken,
-		uv.tokenHashRequired,
 		uv.requireEmail,
 		uv.normalizeEmail,
 		uv.checkEmailFormat,
@@ -105,74 +113,119 @@ func (uv *userValidator) Delete(id uint) error {
 	return uv.UserDB.Delete(id)
 }
 
+func (uv *userValidator) Restore(id uint) error {
+	var user User
+	user.ID = id
+	err := userValidationFuncs(&user, uv.idGreaterThan(0))
+	if err != nil {
+		return err
+	}
+
+	return uv.UserDB.Restore(id)
+}
+
 func (uv *userValidator) generatePasswordHash(user *User) error {
 	// If password is not changed, do nothing
 	if user.Password == "" {
 		return nil
 	}
 
-	hasedBytes, err := bcrypt.GenerateFromPassword(
-		[]byte(user.Password+uv.pepper),
-		bcrypt.DefaultCost)
-
+	hash, err := uv.hasher.Hash(user.Password)
 	if err != nil {
 		return err
 	}
 
-	user.PasswordHash = string(hasedBytes)
+	user.PasswordHash = hash
 	user.Password = ""
 	return nil
 }
 
-func (uv *userValidator) setTokenIfNotSet(user *User) error {
-	if user.Token != "" {
+// Closure way, dynamically validates with argument
+func (uv *userValidator) idGreaterThan(num uint) userValidationFunc {
+	return userValidationFunc(func(user *User) error {
+		if user.ID <= num {
+			return ErrInvalidID
+		}
 		return nil
-	}
+	})
+}
 
-	token, err := utils.GenerateToken()
-	if err != nil {
-		return err
-	}
-	user.Token = token
+///////////////////////////////////////////////////////////
+// Username and profile validation
+///////////////////////////////////////////////////////////
 
+func (uv *userValidator) normalizeUsername(user *User) error {
+	user.Username = strings.ToLower(strings.TrimSpace(user.Username))
 	return nil
 }
 
-func (uv *userValidator) hmacHashToken(user *User) error {
-	if user.Token == "" {
-		return nil
+func (uv *userValidator) requireUsername(user *User) error {
+	if user.Username == "" {
+		return ErrUsernameRequired
 	}
-	user.TokenHash = uv.hmac.Hash(user.Token)
 	return nil
 }
 
-// Closure way, dynamically validates with argument
-func (uv *userValidator) idGreaterThan(num uint) userValidationFunc {
-	return userValidationFunc(func(user *User) error {
-		if user.ID <= num {
-			return ErrInvalidID
-		}
+func (uv *userValidator) checkUsernameFormat(user *User) error {
+	if !uv.usernameRegex.MatchString(user.Username) {
+		return ErrUsernameInvalid
+	}
+	return nil
+}
+
+// Usernames picked before they were lowercased and checked are
+// kept as they are, until the user changes them
+func (uv *userValidator) checkChangedUsername(user *User) error {
+	existing, err := uv.UserDB.GetById(user.ID)
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(user.Username) == existing.Username {
+		user.Username = existing.Username
 		return nil
-	})
+	}
+	return userValidationFuncs(user,
+		uv.normalizeUsername,
+		uv.requireUsername,
+		uv.checkUsernameFormat,
+		uv.checkUsernameAvailability)
 }
 
-func (uv *userValidator) tokenMinBytes(user *User) error {
-	if user.Token == "" {
+func (uv *userValidator) checkUsernameAvailability(user *User) error {
+	existing, err := uv.GetByUsername(user.Username)
+	if err == ErrNotFound {
 		return nil
 	}
-	num, err := utils.NumberOfBytes(user.Token)
 	if err != nil {
 		return err
 	}
-	if num < 32 {
-		return ErrTokenTooShort
+
+	if user.ID != existing.ID {
+		return ErrUsernameTaken
 	}
 	return nil
 }
 
-func (uv *userValidator) tokenHashRequired(user *User) error {
-	if user.TokenHash == "" {
-		return ErrTokenRequired
+func (uv *userValidator) checkProfile(user *User) error {
+	user.DisplayName = strings.TrimSpace(user.DisplayName)
+	user.Bio = strings.TrimSpace(user.Bio)
+	user.AvatarURL = strings.TrimSpace(user.AvatarURL)
+
+	if utf8.RuneCountInString(user.DisplayName) > 50 {
+		return ErrDisplayNameTooLong
+	}
+	if utf8.RuneCountInString(user.Bio) > 280 {
+		return ErrBioTooLong
+	}
+	if user.AvatarURL == "" {
+		return nil
+	}
+	if len(user.AvatarURL) > 2048 {
+		return ErrAvatarURLInvalid
+	}
+	u, err := url.Parse(user.AvatarURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return ErrAvatarURLInvalid
 	}
 	return nil
 }
@@ -228,24 +281,21 @@ func (uv *userValidator) checkEmailAvailability(user *User) error {
 ///////////////////////////////////////////////////////////
 
 func (uv *userValidator) passwordRequired(user *User) error {
-	if user.Password == "" {
+	if user.Password == "" && !user.ExternalLogin {
 		return ErrPasswordRequired
 	}
 	return nil
 }
 
-func (uv *userValidator) passwordMinLength(user *User) error {
+func (uv *userValidator) checkPasswordPolicy(user *User) error {
 	if user.Password == "" {
 		return nil
 	}
-	if len(user.Password) < 8 {
-		return ErrPasswordTooShort
-	}
-	return nil
+	return uv.passwordPolicy.Check(user)
 }
 
 func (uv *userValidator) passwordHashRequired(user *User) error {
-	if user.PasswordHash == "" {
+	if user.PasswordHash == "" && !user.ExternalLogin {
 		return ErrPasswordRequired
 	}
 	return nil
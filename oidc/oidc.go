@@ -0,0 +1,168 @@
+// Package oidc signs users in with OpenID Connect identity
+// providers, as a relying party using the authorization code
+// flow with PKCE. Only RS256 signed ID tokens are accepted.
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	ErrTokenInvalid = errors.New("oidc: ID token is not valid")
+	ErrNonceInvalid = errors.New("oidc: ID token nonce does not match")
+)
+
+// Config of a client registered with a provider
+type Config struct {
+	// Issuer is the provider url discovery starts from
+	Issuer       string
+	ClientID     string
+	ClientSecret string
+	RedirectURL  string
+	// Scopes to ask for besides "openid"
+	Scopes []string
+}
+
+// Metadata is the part of a provider's discovery
+// document the client uses
+type Metadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Client talks to one provider
+type Client struct {
+	cfg      Config
+	metadata Metadata
+	client   *http.Client
+
+	mu   sync.Mutex
+	keys map[string]interface{}
+}
+
+// Discover reads the provider's configuration from
+// <issuer>/.well-known/openid-configuration
+func Discover(ctx context.Context, client *http.Client, issuer string) (*Metadata, error) {
+	wellKnown := strings.TrimSuffix(issuer, "/") + "/.well-known/openid-configuration"
+	var metadata Metadata
+	err := getJSON(ctx, client, wellKnown, &metadata)
+	if err != nil {
+		return nil, err
+	}
+
+	// Per the spec, the issuer must be exactly the one asked for
+	if strings.TrimSuffix(metadata.Issuer, "/") != strings.TrimSuffix(issuer, "/") {
+		return nil, fmt.Errorf("oidc: discovery issuer %q does not match %q", metadata.Issuer, issuer)
+	}
+	if metadata.AuthorizationEndpoint == "" || metadata.TokenEndpoint == "" || metadata.JWKSURI == "" {
+		return nil, fmt.Errorf("oidc: discovery document of %q is incomplete", issuer)
+	}
+	return &metadata, nil
+}
+
+// NewClient runs discovery for cfg.Issuer
+func NewClient(ctx context.Context, cfg Config) (*Client, error) {
+	client := &http.Client{Timeout: 10 * time.Second}
+	metadata, err := Discover(ctx, client, cfg.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	return &Client{
+		cfg:      cfg,
+		metadata: *metadata,
+		client:   client,
+	}, nil
+}
+
+// AuthCodeURL is where to send the user to sign in. state and
+// nonce are checked when they come back, and challenge is the
+// PKCE challenge of the verifier passed to Exchange.
+func (c *Client) AuthCodeURL(state, nonce, challenge string) string {
+	v := url.Values{}
+	v.Set("response_type", "code")
+	v.Set("client_id", c.cfg.ClientID)
+	v.Set("redirect_uri", c.cfg.RedirectURL)
+	v.Set("scope", strings.Join(append([]string{"openid"}, c.cfg.Scopes...), " "))
+	v.Set("state", state)
+	v.Set("nonce", nonce)
+	v.Set("code_challenge", challenge)
+	v.Set("code_challenge_method", "S256")
+
+	sep := "?"
+	if strings.Contains(c.metadata.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return c.metadata.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// Exchange trades an authorization code for the ID token,
+// verified against nonce
+func (c *Client) Exchange(ctx context.Context, code, verifier, nonce string) (*Claims, error) {
+	v := url.Values{}
+	v.Set("grant_type", "authorization_code")
+	v.Set("code", code)
+	v.Set("redirect_uri", c.cfg.RedirectURL)
+	v.Set("code_verifier", verifier)
+
+	req, err := http.NewRequest(http.MethodPost, c.metadata.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+
+	res, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+		return nil, fmt.Errorf("oidc: token exchange: %s: %s", res.Status, msg)
+	}
+
+	var tokens struct {
+		IDToken string `json:"id_token"`
+	}
+	err = json.NewDecoder(res.Body).Decode(&tokens)
+	if err != nil {
+		return nil, err
+	}
+	if tokens.IDToken == "" {
+		return nil, fmt.Errorf("oidc: token response has no id_token")
+	}
+	return c.Verify(ctx, tokens.IDToken, nonce)
+}
+
+func getJSON(ctx context.Context, client *http.Client, u string, v interface{}) error {
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Accept", "application/json")
+
+	res, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: GET %s: %s", u, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,310 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"go_rest_pg_starter/oidc"
+	"go_rest_pg_starter/oidc/oidctest"
+)
+
+const redirectURL = "http://app.test/api/auth/test/callback"
+
+var alice = oidctest.User{
+	Subject:       "alice-subject",
+	Email:         "alice@example.com",
+	EmailVerified: true,
+	Name:          "Alice",
+}
+
+func newClient(t *testing.T, provider *oidctest.Provider) *oidc.Client {
+	t.Helper()
+	client, err := oidc.NewClient(context.Background(), oidc.Config{
+		Issuer:       provider.Issuer(),
+		ClientID:     provider.ClientID,
+		ClientSecret: provider.ClientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"email"},
+	})
+	if err != nil {
+		t.Fatalf("NewClient() error = %v", err)
+	}
+	return client
+}
+
+// Follows the authorization url to the provider and returns the
+// query it redirects back with
+func authorize(t *testing.T, authURL string) url.Values {
+	t.Helper()
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+	res, err := client.Get(authURL)
+	if err != nil {
+		t.Fatalf("GET %s error = %v", authURL, err)
+	}
+	res.Body.Close()
+	if res.StatusCode != http.StatusFound {
+		t.Fatalf("GET %s status = %d, want %d", authURL, res.StatusCode, http.StatusFound)
+	}
+	location, err := url.Parse(res.Header.Get("Location"))
+	if err != nil {
+		t.Fatalf("bad redirect: %v", err)
+	}
+	if !strings.HasPrefix(location.String(), redirectURL) {
+		t.Fatalf("redirected to %s, want %s", location, redirectURL)
+	}
+	return location.Query()
+}
+
+func TestDiscover(t *testing.T) {
+	provider := oidctest.NewProvider("client", "secret")
+	defer provider.Close()
+
+	metadata, err := oidc.Discover(context.Background(), http.DefaultClient, provider.Issuer()+"/")
+	if err != nil {
+		t.Fatalf("Discover() error = %v", err)
+	}
+	if metadata.TokenEndpoint != provider.Issuer()+"/token" {
+		t.Errorf("TokenEndpoint = %q, want %q", metadata.TokenEndpoint, provider.Issuer()+"/token")
+	}
+
+	tests := []struct {
+		name     string
+		metadata func(issuer string) oidc.Metadata
+	}{
+		{"other issuer", func(issuer string) oidc.Metadata {
+			return oidc.Metadata{
+				Issuer:                "https://evil.example.com",
+				AuthorizationEndpoint: issuer + "/authorize",
+				TokenEndpoint:         issuer + "/token",
+				JWKSURI:               issuer + "/jwks",
+			}
+		}},
+		{"incomplete", func(issuer string) oidc.Metadata {
+			return oidc.Metadata{
+				Issuer:                issuer,
+				AuthorizationEndpoint: issuer + "/authorize",
+			}
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var server *httptest.Server
+			server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(tt.metadata(server.URL))
+			}))
+			defer server.Close()
+
+			_, err := oidc.Discover(context.Background(), http.DefaultClient, server.URL)
+			if err == nil {
+				t.Error("Discover() error = nil, want an error")
+			}
+		})
+	}
+}
+
+func TestPKCEChallenge(t *testing.T) {
+	// RFC 7636, appendix B
+	got := oidc.PKCEChallenge("dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk")
+	want := "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM"
+	if got != want {
+		t.Errorf("PKCEChallenge() = %q, want %q", got, want)
+	}
+
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		t.Fatalf("NewPKCE() error = %v", err)
+	}
+	if challenge != oidc.PKCEChallenge(verifier) {
+		t.Errorf("NewPKCE() challenge does not match its verifier")
+	}
+}
+
+func TestExchange(t *testing.T) {
+	provider := oidctest.NewProvider("client", "secret")
+	defer provider.Close()
+	provider.SetUser(alice)
+	client := newClient(t, provider)
+
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+	authURL := client.AuthCodeURL("the-state", "the-nonce", challenge)
+	if !strings.Contains(authURL, "scope=openid+email") {
+		t.Errorf("AuthCodeURL() = %s, want scope openid email", authURL)
+	}
+	query := authorize(t, authURL)
+	if query.Get("state") != "the-state" {
+		t.Errorf("state = %q, want %q", query.Get("state"), "the-state")
+	}
+
+	claims, err := client.Exchange(context.Background(), query.Get("code"), verifier, "the-nonce")
+	if err != nil {
+		t.Fatalf("Exchange() error = %v", err)
+	}
+	want := oidc.Claims{
+		Subject:       alice.Subject,
+		Email:         alice.Email,
+		EmailVerified: true,
+		Name:          alice.Name,
+	}
+	if *claims != want {
+		t.Errorf("Exchange() = %+v, want %+v", *claims, want)
+	}
+
+	// Codes are single use
+	_, err = client.Exchange(context.Background(), query.Get("code"), verifier, "the-nonce")
+	if err == nil {
+		t.Error("Exchange() of a used code error = nil, want an error")
+	}
+}
+
+func TestExchangeChecks(t *testing.T) {
+	provider := oidctest.NewProvider("client", "secret")
+	defer provider.Close()
+	provider.SetUser(alice)
+	client := newClient(t, provider)
+
+	verifier, challenge, err := oidc.NewPKCE()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("wrong verifier", func(t *testing.T) {
+		query := authorize(t, client.AuthCodeURL("state", "nonce", challenge))
+		_, err := client.Exchange(context.Background(), query.Get("code"), verifier+"x", "nonce")
+		if err == nil {
+			t.Error("Exchange() error = nil, want an error")
+		}
+	})
+
+	t.Run("wrong nonce", func(t *testing.T) {
+		query := authorize(t, client.AuthCodeURL("state", "nonce", challenge))
+		_, err := client.Exchange(context.Background(), query.Get("code"), verifier, "other-nonce")
+		if err != oidc.ErrNonceInvalid {
+			t.Errorf("Exchange() error = %v, want %v", err, oidc.ErrNonceInvalid)
+		}
+	})
+
+	t.Run("wrong client secret", func(t *testing.T) {
+		other, err := oidc.NewClient(context.Background(), oidc.Config{
+			Issuer:       provider.Issuer(),
+			ClientID:     provider.ClientID,
+			ClientSecret: "wrong",
+			RedirectURL:  redirectURL,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		query := authorize(t, other.AuthCodeURL("state", "nonce", challenge))
+		_, err = other.Exchange(context.Background(), query.Get("code"), verifier, "nonce")
+		if err == nil {
+			t.Error("Exchange() error = nil, want an error")
+		}
+	})
+}
+
+func TestVerify(t *testing.T) {
+	provider := oidctest.NewProvider("client", "secret")
+	defer provider.Close()
+	client := newClient(t, provider)
+
+	tests := []struct {
+		name    string
+		change  func(claims map[string]interface{})
+		wantErr error
+	}{
+		{"valid", func(map[string]interface{}) {}, nil},
+		{"audience list", func(c map[string]interface{}) {
+			c["aud"] = []string{"other", "client"}
+		}, nil},
+		{"other issuer", func(c map[string]interface{}) {
+			c["iss"] = "https://evil.example.com"
+		}, oidc.ErrTokenInvalid},
+		{"other audience", func(c map[string]interface{}) {
+			c["aud"] = "other"
+		}, oidc.ErrTokenInvalid},
+		{"expired", func(c map[string]interface{}) {
+			c["exp"] = time.Now().Add(-time.Minute).Unix()
+		}, oidc.ErrTokenInvalid},
+		{"no expiry", func(c map[string]interface{}) {
+			delete(c, "exp")
+		}, oidc.ErrTokenInvalid},
+		{"no subject", func(c map[string]interface{}) {
+			delete(c, "sub")
+		}, oidc.ErrTokenInvalid},
+		{"other nonce", func(c map[string]interface{}) {
+			c["nonce"] = "other"
+		}, oidc.ErrNonceInvalid},
+		{"no nonce", func(c map[string]interface{}) {
+			delete(c, "nonce")
+		}, oidc.ErrNonceInvalid},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			claims := provider.Claims(alice, "nonce")
+			tt.change(claims)
+			idToken, err := provider.IDToken(claims)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = client.Verify(context.Background(), idToken, "nonce")
+			if err != tt.wantErr {
+				t.Errorf("Verify() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestVerifyOtherKey(t *testing.T) {
+	provider := oidctest.NewProvider("client", "secret")
+	defer provider.Close()
+	client := newClient(t, provider)
+
+	// Same claims, signed by another provider's key of the same id
+	other := oidctest.NewProvider("client", "secret")
+	defer other.Close()
+	claims := other.Claims(alice, "nonce")
+	claims["iss"] = provider.Issuer()
+	idToken, err := other.IDToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = client.Verify(context.Background(), idToken, "nonce")
+	if err != oidc.ErrTokenInvalid {
+		t.Errorf("Verify() error = %v, want %v", err, oidc.ErrTokenInvalid)
+	}
+}
+
+func TestVerifyEmailVerifiedString(t *testing.T) {
+	provider := oidctest.NewProvider("client", "secret")
+	defer provider.Close()
+	client := newClient(t, provider)
+
+	claims := provider.Claims(alice, "nonce")
+	claims["email_verified"] = "true"
+	idToken, err := provider.IDToken(claims)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := client.Verify(context.Background(), idToken, "nonce")
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if !got.EmailVerified {
+		t.Error("EmailVerified = false, want true")
+	}
+}
@@ -0,0 +1,143 @@
+// Package pwned checks passwords against known data breaches
+// without revealing them, using the k-anonymity range model of
+// the Pwned Passwords API: only the first 5 characters of the
+// password's SHA-1 hash leave the process.
+package pwned
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultBaseURL = "https://api.pwnedpasswords.com/range/"
+
+// RangeSource returns, for a 5 character SHA-1 prefix, how many
+// times each hash suffix starting with it was seen in breaches.
+// Prefixes and suffixes are uppercase hex.
+type RangeSource interface {
+	Range(prefix string) (map[string]int, error)
+}
+
+// Checker reports whether passwords appeared in breaches
+type Checker struct {
+	source RangeSource
+}
+
+func NewChecker(source RangeSource) *Checker {
+	return &Checker{source: source}
+}
+
+func (c *Checker) IsBreached(password string) (bool, error) {
+	prefix, suffix := splitHash(password)
+	suffixes, err := c.source.Range(prefix)
+	if err != nil {
+		return false, err
+	}
+	return suffixes[suffix] > 0, nil
+}
+
+func splitHash(password string) (prefix, suffix string) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+	return hash[:5], hash[5:]
+}
+
+///////////////////////////////////////////////////////////
+// Pwned Passwords API
+///////////////////////////////////////////////////////////
+
+// HTTPSource fetches ranges from the Pwned Passwords API, or
+// from any service answering the same way
+type HTTPSource struct {
+	baseURL string
+	client  *http.Client
+}
+
+var _ RangeSource = &HTTPSource{}
+
+// NewHTTPSource uses the public API when baseURL is empty
+func NewHTTPSource(baseURL string) *HTTPSource {
+	if baseURL == "" {
+		baseURL = defaultBaseURL
+	}
+	return &HTTPSource{
+		baseURL: baseURL,
+		client:  &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+func (s *HTTPSource) Range(prefix string) (map[string]int, error) {
+	req, err := http.NewRequest(http.MethodGet, s.baseURL+prefix, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Pads responses so their size does not give the prefix away
+	req.Header.Set("Add-Padding", "true")
+
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("pwned: range %s: %s", prefix, res.Status)
+	}
+	return parseRange(res.Body)
+}
+
+// Parses "SUFFIX:COUNT" lines. Padding entries have a count of 0.
+func parseRange(r io.Reader) (map[string]int, error) {
+	suffixes := make(map[string]int)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimSpace(scanner.Text()), ":", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		count, err := strconv.Atoi(parts[1])
+		if err != nil {
+			continue
+		}
+		suffixes[strings.ToUpper(parts[0])] = count
+	}
+	return suffixes, scanner.Err()
+}
+
+///////////////////////////////////////////////////////////
+// Fake
+///////////////////////////////////////////////////////////
+
+// FakeSource serves ranges from a fixed set of breached
+// passwords, for tests and offline development
+type FakeSource struct {
+	ranges map[string]map[string]int
+}
+
+var _ RangeSource = &FakeSource{}
+
+func NewFakeSource(breached ...string) *FakeSource {
+	s := &FakeSource{ranges: make(map[string]map[string]int)}
+	for _, password := range breached {
+		prefix, suffix := splitHash(password)
+		if s.ranges[prefix] == nil {
+			s.ranges[prefix] = make(map[string]int)
+		}
+		s.ranges[prefix][suffix]++
+	}
+	return s
+}
+
+func (s *FakeSource) Range(prefix string) (map[string]int, error) {
+	suffixes := make(map[string]int, len(s.ranges[prefix]))
+	for suffix, count := range s.ranges[prefix] {
+		suffixes[suffix] = count
+	}
+	return suffixes, nil
+}
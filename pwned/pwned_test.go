@@ -0,0 +1,81 @@
+package pwned
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPSource(t *testing.T) {
+	// SHA-1 of "password" is 5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8
+	var requests []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path)
+		if r.Header.Get("Add-Padding") != "true" {
+			t.Error("padding was not asked for")
+		}
+		w.Write([]byte("003D68EB55068C33ACE09247EE4C639306B:3\r\n" +
+			"1e4c9b93f3f0682250b6cf8331b7ee68fd8:9659365\r\n" +
+			"malformed line\r\n" +
+			"01330C689E5D64F660D6947A93AD634EF8F:0\r\n"))
+	}))
+	defer server.Close()
+	checker := NewChecker(NewHTTPSource(server.URL + "/range/"))
+
+	breached, err := checker.IsBreached("password")
+	if err != nil {
+		t.Fatalf("IsBreached() error = %v", err)
+	}
+	if !breached {
+		t.Error("IsBreached() = false, want true")
+	}
+	// Only the prefix is sent
+	if len(requests) != 1 || requests[0] != "/range/5BAA6" {
+		t.Errorf("requests = %v, want [/range/5BAA6]", requests)
+	}
+
+	breached, err = checker.IsBreached("not breached")
+	if err != nil || breached {
+		t.Errorf("IsBreached() = %v, %v, want false", breached, err)
+	}
+}
+
+func TestHTTPSourceError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := NewHTTPSource(server.URL + "/").Range("5BAA6")
+	if err == nil {
+		t.Error("Range() error = nil, want an error")
+	}
+}
+
+func TestParseRange(t *testing.T) {
+	suffixes, err := parseRange(strings.NewReader("abc:2\nDEF:0\nno count\nGHI:x\n"))
+	if err != nil {
+		t.Fatalf("parseRange() error = %v", err)
+	}
+	want := map[string]int{"ABC": 2, "DEF": 0}
+	if len(suffixes) != len(want) {
+		t.Fatalf("parseRange() = %v, want %v", suffixes, want)
+	}
+	for suffix, count := range want {
+		if got, ok := suffixes[suffix]; !ok || got != count {
+			t.Errorf("parseRange()[%s] = %d, %v, want %d", suffix, got, ok, count)
+		}
+	}
+}
+
+func TestFakeSource(t *testing.T) {
+	checker := NewChecker(NewFakeSource("hunter2"))
+
+	for password, want := range map[string]bool{"hunter2": true, "hunter3": false} {
+		got, err := checker.IsBreached(password)
+		if err != nil || got != want {
+			t.Errorf("IsBreached(%q) = %v, %v, want %v", password, got, err, want)
+		}
+	}
+}
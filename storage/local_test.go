@@ -0,0 +1,110 @@
+package storage_test
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"go_rest_pg_starter/storage"
+)
+
+// Keys that could reach outside of a store's root
+var invalidKeys = []string{
+	"",
+	"/etc/passwd",
+	"../secret",
+	"attachments/../../secret",
+	"attachments/./1",
+	"attachments//1",
+	"attachments/1/",
+	".",
+}
+
+func newLocalStore(t *testing.T) (*storage.LocalStore, string) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "local-store-")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	root := filepath.Join(dir, "uploads")
+	store, err := storage.NewLocalStore(root)
+	if err != nil {
+		t.Fatalf("NewLocalStore() error = %v", err)
+	}
+	return store, root
+}
+
+func TestLocalStore(t *testing.T) {
+	store, root := newLocalStore(t)
+	ctx := context.Background()
+	key := "attachments/1/abc"
+
+	err := store.Put(ctx, key, strings.NewReader("first"), 5, "text/plain")
+	if err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	err = store.Put(ctx, key, strings.NewReader("second"), 6, "text/plain")
+	if err != nil {
+		t.Fatalf("Put() over a blob error = %v", err)
+	}
+
+	blob, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatalf("Get() error = %v", err)
+	}
+	got, err := ioutil.ReadAll(blob)
+	blob.Close()
+	if err != nil || string(got) != "second" {
+		t.Errorf("Get() = %q, %v, want %q", got, err, "second")
+	}
+
+	// Nothing but the blob, no temporary files
+	files, err := ioutil.ReadDir(filepath.Join(root, "attachments", "1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 || files[0].Name() != "abc" {
+		t.Errorf("files = %v, want only abc", files)
+	}
+
+	err = store.Delete(ctx, key)
+	if err != nil {
+		t.Fatalf("Delete() error = %v", err)
+	}
+	_, err = store.Get(ctx, key)
+	if err != storage.ErrNotFound {
+		t.Errorf("Get() after Delete() error = %v, want %v", err, storage.ErrNotFound)
+	}
+	err = store.Delete(ctx, key)
+	if err != nil {
+		t.Errorf("Delete() of a missing blob error = %v, want nil", err)
+	}
+}
+
+func TestLocalStoreInvalidKey(t *testing.T) {
+	store, root := newLocalStore(t)
+	ctx := context.Background()
+
+	for _, key := range invalidKeys {
+		err := store.Put(ctx, key, strings.NewReader("x"), 1, "")
+		if err != storage.ErrInvalidKey {
+			t.Errorf("Put(%q) error = %v, want %v", key, err, storage.ErrInvalidKey)
+		}
+		_, err = store.Get(ctx, key)
+		if err != storage.ErrInvalidKey {
+			t.Errorf("Get(%q) error = %v, want %v", key, err, storage.ErrInvalidKey)
+		}
+		err = store.Delete(ctx, key)
+		if err != storage.ErrInvalidKey {
+			t.Errorf("Delete(%q) error = %v, want %v", key, err, storage.ErrInvalidKey)
+		}
+	}
+
+	if _, err := os.Stat(filepath.Join(root, "..", "secret")); !os.IsNotExist(err) {
+		t.Errorf("wrote outside of the store: %v", err)
+	}
+}
@@ -0,0 +1,41 @@
+package storage
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"strconv"
+	"time"
+)
+
+// URLSigner signs resources (usually url paths) with an expiry
+// time, so that links to private blobs can be handed out
+// without requiring the bearer to be logged in.
+type URLSigner struct {
+	key []byte
+}
+
+func NewURLSigner(key string) *URLSigner {
+	return &URLSigner{key: []byte(key)}
+}
+
+// Sign returns the signature of the resource, valid until expires
+func (s *URLSigner) Sign(resource string, expires time.Time) string {
+	return s.signature(resource, expires.Unix())
+}
+
+// Verify reports whether signature was made by Sign for the
+// resource and expires (unix seconds), and has not expired yet.
+func (s *URLSigner) Verify(resource string, expires int64, signature string, now time.Time) bool {
+	if now.Unix() > expires {
+		return false
+	}
+	expected := s.signature(resource, expires)
+	return hmac.Equal([]byte(expected), []byte(signature))
+}
+
+func (s *URLSigner) signature(resource string, expires int64) string {
+	h := hmac.New(sha256.New, s.key)
+	h.Write([]byte(resource + "\n" + strconv.FormatInt(expires, 10)))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
@@ -0,0 +1,42 @@
+package storage_test
+
+import (
+	"testing"
+	"time"
+
+	"go_rest_pg_starter/storage"
+)
+
+func TestURLSigner(t *testing.T) {
+	signer := storage.NewURLSigner("key")
+	now := time.Unix(1700000000, 0)
+	expires := now.Add(time.Hour)
+	resource := "/api/attachments/1/download"
+	signature := signer.Sign(resource, expires)
+
+	tests := []struct {
+		name      string
+		signer    *storage.URLSigner
+		resource  string
+		expires   int64
+		signature string
+		now       time.Time
+		want      bool
+	}{
+		{"valid", signer, resource, expires.Unix(), signature, now, true},
+		{"at expiry", signer, resource, expires.Unix(), signature, expires, true},
+		{"expired", signer, resource, expires.Unix(), signature, expires.Add(time.Second), false},
+		{"later expiry", signer, resource, expires.Unix() + 1, signature, now, false},
+		{"other resource", signer, "/api/attachments/2/download", expires.Unix(), signature, now, false},
+		{"other key", storage.NewURLSigner("other key"), resource, expires.Unix(), signature, now, false},
+		{"no signature", signer, resource, expires.Unix(), "", now, false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := tt.signer.Verify(tt.resource, tt.expires, tt.signature, tt.now)
+			if got != tt.want {
+				t.Errorf("Verify() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
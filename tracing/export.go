@@ -0,0 +1,295 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exporter sends ended spans somewhere
+type Exporter interface {
+	ExportSpans(ctx context.Context, spans []SpanData) error
+}
+
+const (
+	// Spans are sent once this many are waiting, or every
+	// batchInterval
+	batchSize     = 512
+	batchInterval = 5 * time.Second
+	// Spans ending while this many are waiting are dropped, so a
+	// slow collector cannot take the app's memory
+	maxQueuedSpans = 4096
+	exportTimeout  = 10 * time.Second
+)
+
+// batcher exports spans in the background
+type batcher struct {
+	exporter Exporter
+	spans    chan SpanData
+	stop     chan struct{}
+	done     chan struct{}
+	once     sync.Once
+}
+
+func newBatcher(exporter Exporter) *batcher {
+	b := &batcher{
+		exporter: exporter,
+		spans:    make(chan SpanData, maxQueuedSpans),
+		stop:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go b.loop()
+	return b
+}
+
+func (b *batcher) enqueue(span SpanData) {
+	select {
+	case b.spans <- span:
+	default:
+	}
+}
+
+func (b *batcher) loop() {
+	defer close(b.done)
+	ticker := time.NewTicker(batchInterval)
+	defer ticker.Stop()
+
+	var batch []SpanData
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), exportTimeout)
+		// Failing to export must not affect the app, the spans
+		// are lost
+		b.exporter.ExportSpans(ctx, batch)
+		cancel()
+		batch = nil
+	}
+
+	for {
+		select {
+		case span := <-b.spans:
+			batch = append(batch, span)
+			if len(batch) >= batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-b.stop:
+			for {
+				select {
+				case span := <-b.spans:
+					batch = append(batch, span)
+				default:
+					flush()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *batcher) shutdown(ctx context.Context) error {
+	b.once.Do(func() {
+		close(b.stop)
+	})
+	select {
+	case <-b.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// OTLPExporter sends spans to an OpenTelemetry collector with
+// OTLP over HTTP, JSON encoded
+type OTLPExporter struct {
+	url         string
+	serviceName string
+	headers     map[string]string
+	client      *http.Client
+}
+
+// NewOTLPExporter sends spans to <endpoint>/v1/traces, like
+// http://localhost:4318, with the given extra headers
+func NewOTLPExporter(endpoint, serviceName string, headers map[string]string) *OTLPExporter {
+	return &OTLPExporter{
+		url:         strings.TrimRight(endpoint, "/") + "/v1/traces",
+		serviceName: serviceName,
+		headers:     headers,
+		client:      &http.Client{Timeout: exportTimeout},
+	}
+}
+
+func (e *OTLPExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	body, err := json.Marshal(otlpRequest(e.serviceName, spans))
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, e.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range e.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, resp.Body)
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("tracing: collector answered %s", resp.Status)
+	}
+	return nil
+}
+
+// WriterExporter writes each batch of spans as a line of OTLP
+// JSON, for local use
+type WriterExporter struct {
+	w           io.Writer
+	serviceName string
+	mu          sync.Mutex
+}
+
+func NewWriterExporter(w io.Writer, serviceName string) *WriterExporter {
+	return &WriterExporter{
+		w:           w,
+		serviceName: serviceName,
+	}
+}
+
+func (e *WriterExporter) ExportSpans(ctx context.Context, spans []SpanData) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return json.NewEncoder(e.w).Encode(otlpRequest(e.serviceName, spans))
+}
+
+///////////////////////////////////////////////////////////
+// OTLP JSON encoding
+///////////////////////////////////////////////////////////
+
+type otlpAnyValue struct {
+	StringValue *string  `json:"stringValue,omitempty"`
+	BoolValue   *bool    `json:"boolValue,omitempty"`
+	IntValue    *string  `json:"intValue,omitempty"`
+	DoubleValue *float64 `json:"doubleValue,omitempty"`
+}
+
+type otlpKeyValue struct {
+	Key   string       `json:"key"`
+	Value otlpAnyValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string         `json:"traceId"`
+	SpanID            string         `json:"spanId"`
+	ParentSpanID      string         `json:"parentSpanId,omitempty"`
+	Name              string         `json:"name"`
+	Kind              SpanKind       `json:"kind"`
+	StartTimeUnixNano string         `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+	Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+	Status            otlpStatus     `json:"status"`
+}
+
+type otlpScopeSpans struct {
+	Scope struct {
+		Name string `json:"name"`
+	} `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	} `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+type otlpTraces struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// Status codes of OTLP
+const (
+	otlpStatusOK    = 1
+	otlpStatusError = 2
+)
+
+func otlpRequest(serviceName string, spans []SpanData) otlpTraces {
+	scope := otlpScopeSpans{}
+	scope.Scope.Name = "go_rest_pg_starter/tracing"
+	for _, span := range spans {
+		s := otlpSpan{
+			TraceID:           span.Context.TraceID.String(),
+			SpanID:            span.Context.SpanID.String(),
+			Name:              span.Name,
+			Kind:              span.Kind,
+			StartTimeUnixNano: strconv.FormatInt(span.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(span.End.UnixNano(), 10),
+			Attributes:        otlpAttributes(span.Attributes),
+			Status:            otlpStatus{Code: otlpStatusOK},
+		}
+		if span.Parent.IsValid() {
+			s.ParentSpanID = span.Parent.String()
+		}
+		if span.Error {
+			s.Status = otlpStatus{Code: otlpStatusError, Message: span.StatusMessage}
+		}
+		scope.Spans = append(scope.Spans, s)
+	}
+
+	resource := otlpResourceSpans{ScopeSpans: []otlpScopeSpans{scope}}
+	resource.Resource.Attributes = otlpAttributes(map[string]interface{}{
+		"service.name": serviceName,
+	})
+	return otlpTraces{ResourceSpans: []otlpResourceSpans{resource}}
+}
+
+func otlpAttributes(attrs map[string]interface{}) []otlpKeyValue {
+	var kvs []otlpKeyValue
+	for key, value := range attrs {
+		var v otlpAnyValue
+		switch value := value.(type) {
+		case string:
+			v.StringValue = &value
+		case bool:
+			v.BoolValue = &value
+		case int:
+			s := strconv.Itoa(value)
+			v.IntValue = &s
+		case int64:
+			s := strconv.FormatInt(value, 10)
+			v.IntValue = &s
+		case uint:
+			s := strconv.FormatUint(uint64(value), 10)
+			v.IntValue = &s
+		case float64:
+			v.DoubleValue = &value
+		default:
+			s := fmt.Sprint(value)
+			v.StringValue = &s
+		}
+		kvs = append(kvs, otlpKeyValue{Key: key, Value: v})
+	}
+	return kvs
+}
@@ -4,25 +4,33 @@ import (
 	"crypto/hmac"
 	"crypto/sha256"
 	"encoding/base64"
-	"hash"
 )
 
+// HMAC is safe for concurrent use
 type HMAC struct {
-	hmac hash.Hash
+	key []byte
 }
 
 func NewHMAC(key string) HMAC {
-	h := hmac.New(sha256.New, []byte(key))
 	return HMAC{
-		hmac: h,
+		key: []byte(key),
 	}
 }
 
 // Hash will hash the provided input string using HMAC with
 // the secret key provided when the HMAC object was created
 func (h HMAC) Hash(input string) string {
-	h.hmac.Reset()
-	h.hmac.Write([]byte(input))
-	hashedData := h.hmac.Sum(nil)
+	// A hash.Hash keeps state, so one is made per call rather
+	// than shared between the requests hashing at the same time
+	mac := hmac.New(sha256.New, h.key)
+	mac.Write([]byte(input))
+	hashedData := mac.Sum(nil)
 	return base64.URLEncoding.EncodeToString(hashedData)
 }
+
+// DeriveKey returns a key made from a secret key for one purpose
+// only, so that a value signed for one use is never accepted for
+// another
+func DeriveKey(key, purpose string) string {
+	return NewHMAC(key).Hash("purpose:" + purpose)
+}
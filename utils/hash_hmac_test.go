@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+)
+
+func TestHMACConcurrent(t *testing.T) {
+	h := NewHMAC("key")
+	want := make([]string, 100)
+	for i := range want {
+		want[i] = h.Hash(strconv.Itoa(i))
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < 50; n++ {
+				for i, w := range want {
+					if got := h.Hash(strconv.Itoa(i)); got != w {
+						t.Errorf("Hash(%d) = %q, want %q", i, got, w)
+						return
+					}
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}